@@ -1,44 +1,55 @@
 package binance
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rsa"
 	"crypto/sha512"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/kmcqqq/pkg/config"
 	"github.com/kmcqqq/pkg/httpHelper"
-	"github.com/kmcqqq/pkg/logger"
 	"github.com/kmcqqq/pkg/utils"
 	"github.com/mitchellh/mapstructure"
-	"reflect"
+	"net/http"
 	"strings"
 	"time"
 )
 
 type Client struct {
+	httpHelper.ClientHolder
 	ApiKey    string
 	SecretKey string
 	Url       string
+	// PublicKey 平台公钥
+	//
+	// Deprecated: 证书在首次验签时按需拉取并按序列号缓存，此字段不再填充，请使用 GetPublicKey
 	PublicKey *rsa.PublicKey
+
+	certs *certStore
 }
 
+// NewClient 不发起网络请求，平台证书在首次调用 GetPublicKey 或验签时拉取
 func NewClient(cfg *config.PayConfig) (*Client, error) {
 	var client = Client{
 		ApiKey:    cfg.Binance.ApiKey,
 		SecretKey: cfg.Binance.SecretKey,
 		Url:       cfg.Binance.Url,
+		certs:     newCertStore(),
 	}
-	publicKey, err := client.getPublicKey()
-	if err != nil {
-		logger.Error("error", logger.String("text", "binance getPublicKey error"), logger.Err(err))
-	}
-	client.PublicKey = publicKey
 
 	return &client, nil
 }
 
+// WithContext 返回绑定 ctx 的浅拷贝，请求随 ctx 取消
+func (b *Client) WithContext(ctx context.Context) *Client {
+	client := *b
+	client.ClientHolder = b.ClientHolder.WithContext(ctx)
+	return &client
+}
+
 type PayOrderRequest struct {
 	Env struct {
 		TerminalType string `json:"terminalType"`
@@ -81,7 +92,8 @@ func (b *Client) Do(url string, req interface{}) (*Response, error) {
 		"BinancePay-Certificate-SN": b.ApiKey,
 		"BinancePay-Signature":      signature,
 	}
-	res, err := httpHelper.Post(fullUrl, req, header)
+	ctx, client := b.Client()
+	res, err := client.Post(ctx, fullUrl, req, header)
 	if err != nil {
 		return nil, err
 	}
@@ -157,39 +169,196 @@ func (b *Client) PayOrderQuery(req *QueryPayOrderRequest) (*QueryOrderResult, er
 	}
 }
 
-type certificates struct {
-	CertSerial string `json:"certSerial"`
-	CertPublic string `json:"certPublic"`
+type CloseOrderRequest struct {
+	MerchantTradeNo string `json:"merchantTradeNo,omitempty"`
+	PrepayId        string `json:"prepayId,omitempty"`
+}
+
+// CloseOrder 关闭订单
+func (b *Client) CloseOrder(req *CloseOrderRequest) error {
+	url := "/binancepay/openapi/order/close"
+	response, err := b.Do(url, req)
+	if err != nil {
+		return err
+	}
+
+	if response.Status == "SUCCESS" {
+		return nil
+	} else {
+		return errors.New(response.ErrorMessage)
+	}
+}
+
+type RefundRequest struct {
+	RefundRequestId string  `json:"refundRequestId"`
+	PrepayId        string  `json:"prepayId"`
+	RefundAmount    float64 `json:"refundAmount"`
+	RefundReason    string  `json:"refundReason,omitempty"`
+	WebhookUrl      string  `json:"webhookUrl,omitempty"`
+}
+
+type RefundResult struct {
+	RefundRequestId   string  `json:"refundRequestId"`
+	PrepayId          string  `json:"prepayId"`
+	OrderAmount       float64 `json:"orderAmount"`
+	RefundedAmount    float64 `json:"refundedAmount"`
+	RefundAmount      float64 `json:"refundAmount"`
+	RemainingAttempts int     `json:"remainingAttempts"`
+	PayerOpenId       string  `json:"payerOpenId"`
+	DuplicateRequest  string  `json:"duplicateRequest"`
+	RefundStatus      string  `json:"refundStatus"`
 }
 
-// 获取公钥
-func (b *Client) getPublicKey() (*rsa.PublicKey, error) {
-	url := "/binancepay/openapi/certificates"
-	response, err := b.Do(url, nil)
+// Refund 退款
+func (b *Client) Refund(req *RefundRequest) (*RefundResult, error) {
+	url := "/binancepay/openapi/order/refund"
+	response, err := b.Do(url, req)
 	if err != nil {
 		return nil, err
 	}
 
 	if response.Status == "SUCCESS" {
-		if rv := reflect.ValueOf(response.Data); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
-			if rv.Len() > 0 {
-				var cert certificates
-				if err := mapstructure.Decode(rv.Index(0).Interface(), &cert); err != nil {
-					return nil, errors.New("response.Date format error")
-				}
-				publicKey, err := utils.LoadPublicKey(cert.CertPublic, false)
-				if err != nil {
-					return nil, err
-				}
-				return publicKey, nil
-			}
+		var refund RefundResult
+		if err := decodeData(response.Data, &refund); err != nil {
+			return nil, errors.New("response.Date format error")
 		}
-		return nil, errors.New("response.Date format error")
+
+		return &refund, nil
 	} else {
 		return nil, errors.New(response.ErrorMessage)
 	}
 }
 
+type QueryRefundRequest struct {
+	RefundRequestId string `json:"refundRequestId"`
+}
+
+// QueryRefund 退款查询
+func (b *Client) QueryRefund(req *QueryRefundRequest) (*RefundResult, error) {
+	url := "/binancepay/openapi/order/refund/query"
+	response, err := b.Do(url, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Status == "SUCCESS" {
+		var refund RefundResult
+		if err := decodeData(response.Data, &refund); err != nil {
+			return nil, errors.New("response.Date format error")
+		}
+
+		return &refund, nil
+	} else {
+		return nil, errors.New(response.ErrorMessage)
+	}
+}
+
+type TransferRequest struct {
+	RequestId          string           `json:"requestId"`
+	BatchName          string           `json:"batchName"`
+	Currency           string           `json:"currency"`
+	TotalAmount        float64          `json:"totalAmount"`
+	TotalNumber        int              `json:"totalNumber"`
+	BizScene           string           `json:"bizScene,omitempty"`
+	TransferDetailList []TransferDetail `json:"transferDetailList"`
+}
+
+type TransferDetail struct {
+	MerchantSendId string  `json:"merchantSendId"`
+	ReceiveType    string  `json:"receiveType"`
+	Receiver       string  `json:"receiver"`
+	TransferAmount float64 `json:"transferAmount"`
+	TransferMethod string  `json:"transferMethod"`
+	Remark         string  `json:"remark,omitempty"`
+}
+
+type TransferResult struct {
+	RequestId string `json:"requestId"`
+	Status    string `json:"status"`
+}
+
+// Transfer 批量代付，TotalAmount/TotalNumber 为空时按明细汇总
+func (b *Client) Transfer(req *TransferRequest) (*TransferResult, error) {
+	if req.TotalNumber == 0 {
+		req.TotalNumber = len(req.TransferDetailList)
+	}
+	if req.TotalAmount == 0 {
+		for _, detail := range req.TransferDetailList {
+			req.TotalAmount += detail.TransferAmount
+		}
+	}
+
+	url := "/binancepay/openapi/payout/transfer"
+	response, err := b.Do(url, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Status == "SUCCESS" {
+		var transfer TransferResult
+		if err := decodeData(response.Data, &transfer); err != nil {
+			return nil, errors.New("response.Date format error")
+		}
+
+		return &transfer, nil
+	} else {
+		return nil, errors.New(response.ErrorMessage)
+	}
+}
+
+type QueryTransferRequest struct {
+	RequestId    string   `json:"requestId"`
+	DetailStatus []string `json:"detailStatus,omitempty"`
+}
+
+type QueryTransferResult struct {
+	RequestId          string  `json:"requestId"`
+	BatchStatus        string  `json:"batchStatus"`
+	MerchantId         int64   `json:"merchantId"`
+	Currency           string  `json:"currency"`
+	TotalAmount        float64 `json:"totalAmount"`
+	TotalNumber        int     `json:"totalNumber"`
+	TransferDetailList []struct {
+		DetailId       int64   `json:"detailId"`
+		MerchantSendId string  `json:"merchantSendId"`
+		Receiver       string  `json:"receiver"`
+		TransferAmount float64 `json:"transferAmount"`
+		Status         string  `json:"status"`
+	} `json:"transferDetailList"`
+}
+
+// QueryTransfer 代付查询
+func (b *Client) QueryTransfer(req *QueryTransferRequest) (*QueryTransferResult, error) {
+	url := "/binancepay/openapi/payout/query"
+	response, err := b.Do(url, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Status == "SUCCESS" {
+		var transfer QueryTransferResult
+		if err := decodeData(response.Data, &transfer); err != nil {
+			return nil, errors.New("response.Date format error")
+		}
+
+		return &transfer, nil
+	} else {
+		return nil, errors.New(response.ErrorMessage)
+	}
+}
+
+// decodeData 数字字段在 Data 中可能是数字或字符串，使用弱类型解码
+func decodeData(data interface{}, out interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		Result:           out,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(data)
+}
+
 type PayNotify struct {
 	BizType   string `json:"bizType"`
 	BizId     string `json:"bizId"`
@@ -212,10 +381,19 @@ type PayNotifySign struct {
 	Nonce     string `json:"nonce"`
 }
 
-// VerifyWebhookSignature 验证webhook通知
+// VerifyWebhookSignature 使用当前公钥验证webhook通知
 func (b *Client) VerifyWebhookSignature(payload, timestamp, nonce, signature string) (bool, error) {
+	return b.VerifyWebhook("", payload, timestamp, nonce, signature)
+}
+
+// VerifyWebhook 使用 BinancePay-Certificate-SN 对应的公钥验证webhook通知，未知序列号会重新拉取证书
+func (b *Client) VerifyWebhook(certSerial, payload, timestamp, nonce, signature string) (bool, error) {
+	publicKey, err := b.GetPublicKey(certSerial)
+	if err != nil {
+		return false, err
+	}
 	signString := timestamp + "\n" + nonce + "\n" + payload + "\n"
-	verify, err := utils.VerySignWithRsa(signString, signature, b.PublicKey)
+	verify, err := utils.VerySignWithRsa(signString, signature, publicKey)
 	return verify, err
 }
 
@@ -226,3 +404,128 @@ func (b *Client) signPayload(timestamp, nonce, payload string) string {
 	h.Write([]byte(signString))
 	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
 }
+
+const (
+	BizTypePay    = "PAY"
+	BizTypeRefund = "PAY_REFUND"
+	BizTypePayout = "PAYOUT"
+)
+
+type RefundNotify struct {
+	BizType   string `json:"bizType"`
+	BizId     string `json:"bizId"`
+	BizIdStr  string `json:"bizIdStr"`
+	BizStatus string `json:"bizStatus"`
+	Data      struct {
+		MerchantTradeNo string  `json:"merchantTradeNo"`
+		ProductType     string  `json:"productType"`
+		ProductName     string  `json:"productName"`
+		TransactTime    int64   `json:"transactTime"`
+		TradeType       string  `json:"tradeType"`
+		TotalFee        float64 `json:"totalFee"`
+		Currency        string  `json:"currency"`
+		RefundInfo      struct {
+			RefundRequestId   string  `json:"refundRequestId"`
+			PrepayId          string  `json:"prepayId"`
+			OrderAmount       float64 `json:"orderAmount"`
+			RefundedAmount    float64 `json:"refundedAmount"`
+			RefundAmount      float64 `json:"refundAmount"`
+			RemainingAttempts int     `json:"remainingAttempts"`
+			PayerOpenId       string  `json:"payerOpenId"`
+			DuplicateRequest  string  `json:"duplicateRequest"`
+		} `json:"refundInfo"`
+	} `json:"data"`
+}
+
+type PayoutNotify struct {
+	BizType   string `json:"bizType"`
+	BizId     string `json:"bizId"`
+	BizIdStr  string `json:"bizIdStr"`
+	BizStatus string `json:"bizStatus"`
+	Data      struct {
+		MerchantId         int64   `json:"merchantId"`
+		RequestId          string  `json:"requestId"`
+		BatchStatus        string  `json:"batchStatus"`
+		Currency           string  `json:"currency"`
+		TotalAmount        float64 `json:"totalAmount"`
+		TotalNumber        int     `json:"totalNumber"`
+		TransferDetailList []struct {
+			DetailId       int64   `json:"detailId"`
+			MerchantSendId string  `json:"merchantSendId"`
+			Receiver       string  `json:"receiver"`
+			TransferAmount float64 `json:"transferAmount"`
+			Status         string  `json:"status"`
+		} `json:"transferDetailList"`
+	} `json:"data"`
+}
+
+// WebhookEvent 按 BizType 分发后的 webhook 事件，仅对应字段非空
+type WebhookEvent struct {
+	BizType string
+	Pay     *PayNotify
+	Refund  *RefundNotify
+	Payout  *PayoutNotify
+}
+
+var ErrInvalidSignature = errors.New("binance: invalid webhook signature")
+
+// ParseWebhook 校验 webhook 签名并按 BizType 解析为对应事件
+func (b *Client) ParseWebhook(header http.Header, body []byte) (*WebhookEvent, error) {
+	verify, err := b.VerifyWebhook(header.Get("BinancePay-Certificate-SN"), string(body), header.Get("BinancePay-Timestamp"), header.Get("BinancePay-Nonce"), header.Get("BinancePay-Signature"))
+	if err != nil || !verify {
+		return nil, ErrInvalidSignature
+	}
+
+	var envelope struct {
+		BizType   string          `json:"bizType"`
+		BizId     json.Number     `json:"bizId"`
+		BizIdStr  string          `json:"bizIdStr"`
+		BizStatus string          `json:"bizStatus"`
+		Data      json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+
+	// data 可能是 json 字符串，统一还原为对象
+	data := []byte(envelope.Data)
+	if len(data) > 0 && data[0] == '"' {
+		var raw string
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		data = []byte(raw)
+	}
+
+	event := &WebhookEvent{BizType: envelope.BizType}
+	var target interface{}
+	switch envelope.BizType {
+	case BizTypePay:
+		event.Pay = &PayNotify{BizType: envelope.BizType, BizId: envelope.BizId.String(), BizIdStr: envelope.BizIdStr, BizStatus: envelope.BizStatus}
+		target = &event.Pay.Data
+	case BizTypeRefund:
+		event.Refund = &RefundNotify{BizType: envelope.BizType, BizId: envelope.BizId.String(), BizIdStr: envelope.BizIdStr, BizStatus: envelope.BizStatus}
+		target = &event.Refund.Data
+	case BizTypePayout:
+		event.Payout = &PayoutNotify{BizType: envelope.BizType, BizId: envelope.BizId.String(), BizIdStr: envelope.BizIdStr, BizStatus: envelope.BizStatus}
+		target = &event.Payout.Data
+	default:
+		return nil, fmt.Errorf("binance: unsupported bizType %s", envelope.BizType)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, target); err != nil {
+			return nil, err
+		}
+	}
+
+	return event, nil
+}
+
+// WriteWebhookAck 回写 binance 要求的 webhook 应答
+func WriteWebhookAck(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(`{"returnCode":"SUCCESS","returnMessage":null}`))
+	return err
+}
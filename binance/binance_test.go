@@ -0,0 +1,65 @@
+package binance
+
+import (
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/httpHelper"
+	"github.com/kmcqqq/pkg/httpHelper/recorder"
+	"net/http"
+	"testing"
+)
+
+// headerChecker 确认签名相关 header 已发送，golden 文件不保存这些 header
+type headerChecker struct {
+	next http.RoundTripper
+	t    *testing.T
+}
+
+func (h *headerChecker) RoundTrip(r *http.Request) (*http.Response, error) {
+	for _, name := range []string{"BinancePay-Timestamp", "BinancePay-Nonce", "BinancePay-Certificate-SN", "BinancePay-Signature"} {
+		if r.Header.Get(name) == "" {
+			h.t.Errorf("%s: missing header %s", r.URL.Path, name)
+		}
+	}
+	return h.next.RoundTrip(r)
+}
+
+func TestRefundReplay(t *testing.T) {
+	rec, err := recorder.New("testdata/refund.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewClient(&config.PayConfig{Binance: config.BinanceConfig{
+		ApiKey:    "api-key",
+		SecretKey: "secret-key",
+		Url:       "https://bpay.binanceapi.com",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.HttpClient = httpHelper.NewClient(&httpHelper.ClientConfig{Transport: &headerChecker{next: rec, t: t}})
+
+	refund, err := client.Refund(&RefundRequest{
+		RefundRequestId: "68711039982968832",
+		PrepayId:        "383729303729303",
+		RefundAmount:    50,
+		RefundReason:    "user request",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.RefundRequestId != "68711039982968832" || refund.OrderAmount != 100.11 || refund.RefundAmount != 50 {
+		t.Fatalf("unexpected refund %+v", refund)
+	}
+
+	query, err := client.QueryRefund(&QueryRefundRequest{RefundRequestId: "68711039982968832"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.RefundStatus != "REFUNDED" || query.RemainingAttempts != 8 {
+		t.Fatalf("unexpected refund query %+v", query)
+	}
+	if n := rec.Unused(); n != 0 {
+		t.Fatalf("unused = %d", n)
+	}
+}
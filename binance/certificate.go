@@ -0,0 +1,159 @@
+package binance
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/utils"
+	"github.com/mitchellh/mapstructure"
+	"sync"
+	"time"
+)
+
+const (
+	certFetchAttempts = 3
+	certFetchBackoff  = 500 * time.Millisecond
+	// 两次拉取证书的最小间隔，避免伪造的序列号打爆证书接口
+	certRefreshInterval = 30 * time.Second
+)
+
+var ErrUnknownCertificate = errors.New("binance: unknown certificate serial")
+
+type certificates struct {
+	CertSerial string `json:"certSerial"`
+	CertPublic string `json:"certPublic"`
+}
+
+// certStore 按序列号缓存的平台公钥，WithContext 拷贝出的 Client 共享同一份
+type certStore struct {
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	serial    string
+	fetchMu   sync.Mutex
+	lastFetch time.Time
+}
+
+func newCertStore() *certStore {
+	return &certStore{keys: make(map[string]*rsa.PublicKey)}
+}
+
+func (s *certStore) get(serial string) *rsa.PublicKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if serial == "" {
+		serial = s.serial
+	}
+	return s.keys[serial]
+}
+
+func (s *certStore) set(latest string, keys map[string]*rsa.PublicKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for serial, publicKey := range keys {
+		s.keys[serial] = publicKey
+	}
+	s.serial = latest
+}
+
+// certStoreMu 保护未经 NewClient 构造的 Client 的 certs 懒初始化
+var certStoreMu sync.Mutex
+
+func (b *Client) store() *certStore {
+	certStoreMu.Lock()
+	defer certStoreMu.Unlock()
+
+	if b.certs == nil {
+		b.certs = newCertStore()
+	}
+	return b.certs
+}
+
+// GetPublicKey 获取证书序列号对应的公钥，certSerial 为空时返回最近一次拉取的公钥
+func (b *Client) GetPublicKey(certSerial string) (*rsa.PublicKey, error) {
+	certs := b.store()
+	if publicKey := certs.get(certSerial); publicKey != nil {
+		return publicKey, nil
+	}
+
+	if err := b.refreshCertificates(certSerial); err != nil {
+		return nil, err
+	}
+
+	if publicKey := certs.get(certSerial); publicKey != nil {
+		return publicKey, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCertificate, certSerial)
+}
+
+// refreshCertificates 拉取证书，失败时退避重试；退避期间不持有锁，避免阻塞其他 webhook
+func (b *Client) refreshCertificates(certSerial string) error {
+	var err error
+	for i := 0; i < certFetchAttempts; i++ {
+		if i > 0 {
+			time.Sleep(certFetchBackoff << (i - 1))
+		}
+		var fetched bool
+		if fetched, err = b.tryFetchCertificates(certSerial); err == nil {
+			return nil
+		}
+		if !fetched {
+			return nil
+		}
+		logger.Warn("binance", logger.String("text", "fetch certificates failed"), logger.Int("attempt", i+1), logger.Err(err))
+	}
+	return err
+}
+
+// tryFetchCertificates 串行拉取证书，拿到锁后若缓存已命中或最近成功拉取过则不再请求，fetched 为 false
+func (b *Client) tryFetchCertificates(certSerial string) (fetched bool, err error) {
+	certs := b.store()
+	certs.fetchMu.Lock()
+	defer certs.fetchMu.Unlock()
+
+	if certs.get(certSerial) != nil {
+		return false, nil
+	}
+	if !certs.lastFetch.IsZero() && time.Since(certs.lastFetch) < certRefreshInterval {
+		return false, nil
+	}
+
+	logger.Info("binance", logger.String("text", "refresh certificates"), logger.String("certSerial", certSerial))
+	if err := b.fetchCertificates(certs); err != nil {
+		return true, err
+	}
+	certs.lastFetch = time.Now()
+	return true, nil
+}
+
+// fetchCertificates 拉取平台证书并按序列号缓存
+func (b *Client) fetchCertificates(certs *certStore) error {
+	url := "/binancepay/openapi/certificates"
+	response, err := b.Do(url, nil)
+	if err != nil {
+		return err
+	}
+
+	if response.Status != "SUCCESS" {
+		return errors.New(response.ErrorMessage)
+	}
+
+	var list []certificates
+	if err := mapstructure.Decode(response.Data, &list); err != nil || len(list) == 0 {
+		return errors.New("response.Date format error")
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(list))
+	for _, cert := range list {
+		publicKey, err := utils.LoadPublicKey(cert.CertPublic, false)
+		if err != nil {
+			return err
+		}
+		keys[cert.CertSerial] = publicKey
+	}
+
+	certs.set(list[0].CertSerial, keys)
+	return nil
+}
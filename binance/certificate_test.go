@@ -0,0 +1,80 @@
+package binance
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(&config.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+func TestCertificatesFetchedOnFirstVerify(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPublic := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/binancepay/openapi/certificates" {
+			http.NotFound(w, r)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		body, _ := utils.Struct2Json(map[string]interface{}{
+			"status": "SUCCESS",
+			"data":   []map[string]string{{"certSerial": "sn-1", "certPublic": certPublic}},
+		})
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.PayConfig{Binance: config.BinanceConfig{Url: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 0 {
+		t.Fatalf("NewClient fetched certificates %d times", n)
+	}
+
+	payload, timestamp, nonce := `{"bizType":"PAY"}`, "1700000000000", "nonce"
+	signature, err := utils.SignRsa(timestamp+"\n"+nonce+"\n"+payload+"\n", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		ok, err := client.VerifyWebhook("sn-1", payload, timestamp, nonce, signature)
+		if err != nil || !ok {
+			t.Fatalf("verify = %v, %v", ok, err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("certificates fetched %d times, want 1", n)
+	}
+
+	// 刚拉取过时未知序列号不再请求证书接口
+	if _, err := client.GetPublicKey("sn-2"); !errors.Is(err, ErrUnknownCertificate) {
+		t.Fatalf("unknown serial: err = %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("certificates fetched %d times, want 1", n)
+	}
+}
@@ -1,32 +1,91 @@
 package coda
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/kmcqqq/pkg/config"
 	"github.com/kmcqqq/pkg/httpHelper"
 	"github.com/kmcqqq/pkg/utils"
+	"net/url"
+	"strings"
+)
+
+const (
+	DefaultBaseUrl     = "https://airtime.codapayments.com/airtime/api/restful/v1.0/Payment"
+	DefaultUrl         = DefaultBaseUrl + "/init/"
+	DefaultRedirectUrl = "https://airtime.codapayments.com/airtime/begin"
+	DefaultItemCode    = "Momo Live"
+)
+
+const (
+	BrowserMobileWeb = "mobile-web"
+	BrowserWeb       = "web"
 )
 
 type Client struct {
-	ApiKey   string
-	Country  int
-	Currency int
-	Url      string
+	httpHelper.ClientHolder
+	ApiKey      string
+	Country     int
+	Currency    int
+	Url         string // init 下单接口完整地址
+	BaseUrl     string // restful Payment 根路径
+	RedirectUrl string
+	ItemCode    string
 }
 
 func NewClient(cfg *config.PayConfig) (map[string]*Client, error) {
 	clients := make(map[string]*Client)
 	for key, value := range cfg.Coda {
-		clients[key] = &Client{
-			ApiKey:   value.ApiKey,
-			Country:  value.Country,
-			Currency: value.Currency,
+		client := &Client{
+			ApiKey:      value.ApiKey,
+			Country:     value.Country,
+			Currency:    value.Currency,
+			RedirectUrl: value.RedirectUrl,
+			ItemCode:    value.ItemCode,
+		}
+		client.Url, client.BaseUrl = resolveUrl(value.Url, value.BaseUrl)
+		if client.RedirectUrl == "" {
+			client.RedirectUrl = DefaultRedirectUrl
+		}
+		if client.ItemCode == "" {
+			client.ItemCode = DefaultItemCode
 		}
+		clients[key] = client
 	}
 	return clients, nil
 }
 
+// WithContext 返回绑定 ctx 的浅拷贝，请求随 ctx 取消
+func (c *Client) WithContext(ctx context.Context) *Client {
+	client := *c
+	client.ClientHolder = c.ClientHolder.WithContext(ctx)
+	return &client
+}
+
+// resolveUrl 返回 init 下单地址和 Payment 根路径；url 不以 /init 结尾时视为根路径，自动补全下单地址
+func resolveUrl(rawUrl, baseUrl string) (string, string) {
+	baseUrl = strings.TrimSuffix(baseUrl, "/")
+	trimmed := strings.TrimSuffix(rawUrl, "/")
+	switch {
+	case trimmed == "":
+		if baseUrl == "" {
+			baseUrl = DefaultBaseUrl
+		}
+		return baseUrl + "/init/", baseUrl
+	case strings.HasSuffix(trimmed, "/init"):
+		if baseUrl == "" {
+			baseUrl = strings.TrimSuffix(trimmed, "/init")
+		}
+		return rawUrl, baseUrl
+	default:
+		if baseUrl == "" {
+			baseUrl = trimmed
+		}
+		return trimmed + "/init/", baseUrl
+	}
+}
+
 type PayOrder struct {
 	OrderId string  `json:"orderId"`
 	Coin    int     `json:"coin"`
@@ -98,7 +157,7 @@ func (c *Client) PayOrder(p PayOrder) (*PayOrderResponse, error) {
 				Type  int     `json:"type"`
 			}{
 				{
-					Code:  "Momo Live",
+					Code:  c.ItemCode,
 					Name:  fmt.Sprintf("%dCoin", p.Coin),
 					Price: p.Amount,
 					Type:  1,
@@ -127,7 +186,8 @@ func (c *Client) PayOrder(p PayOrder) (*PayOrderResponse, error) {
 		},
 	}
 
-	res, err := httpHelper.Post(c.Url, order, nil)
+	ctx, client := c.Client()
+	res, err := client.Post(ctx, c.Url, order, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -157,3 +217,76 @@ func (c *Client) ValidSign(txnId string, orderId string, resultCode string, chec
 
 	return utils.MD5(data) == checksum
 }
+
+var ErrInvalidSign = errors.New("coda: invalid notify checksum")
+
+// VerifyNotify 解析 form 格式的回调并校验 Checksum
+func (c *Client) VerifyNotify(body []byte) (*PayNotify, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	notify := PayNotify{
+		OrderId:    values.Get("OrderId"),
+		TxnId:      values.Get("TxnId"),
+		ResultCode: values.Get("ResultCode"),
+		Checksum:   values.Get("Checksum"),
+		TotalPrice: values.Get("TotalPrice"),
+	}
+	if notify.Checksum == "" || !c.ValidSign(notify.TxnId, notify.OrderId, notify.ResultCode, notify.Checksum) {
+		return nil, ErrInvalidSign
+	}
+
+	return &notify, nil
+}
+
+// BuildRedirectURL 生成 coda 收银台跳转地址，browserType 为 BrowserMobileWeb 或 BrowserWeb
+func (c *Client) BuildRedirectURL(txnId int64, browserType string) string {
+	if browserType == "" {
+		browserType = BrowserMobileWeb
+	}
+	query := url.Values{}
+	query.Set("type", "3")
+	query.Set("txn_id", utils.Int64ToString(txnId))
+	query.Set("browser_type", browserType)
+	return fmt.Sprintf("%s?%s", c.RedirectUrl, query.Encode())
+}
+
+type InquiryRequest struct {
+	InquiryPaymentRequest struct {
+		APIKey string `json:"apiKey"`
+		TxnId  int64  `json:"txnId"`
+	} `json:"inquiryPaymentRequest"`
+}
+
+type InquiryResponse struct {
+	PaymentResult struct {
+		ResultCode  int     `json:"resultCode"`
+		ResultDesc  string  `json:"resultDesc"`
+		TxnId       int64   `json:"txnId"`
+		OrderId     string  `json:"orderId"`
+		TotalPrice  float64 `json:"totalPrice"`
+		PaymentType int     `json:"paymentType"`
+	} `json:"paymentResult"`
+}
+
+// InquiryPaymentResult 根据 txnId 查询支付结果，ResultCode 为 0 表示支付成功
+func (c *Client) InquiryPaymentResult(txnId int64) (*InquiryResponse, error) {
+	var req InquiryRequest
+	req.InquiryPaymentRequest.APIKey = c.ApiKey
+	req.InquiryPaymentRequest.TxnId = txnId
+
+	ctx, client := c.Client()
+	res, err := client.Post(ctx, fmt.Sprintf("%s/inquiryPaymentResult/", c.BaseUrl), req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response InquiryResponse
+	if err = utils.Json2Struct(res, &response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,33 @@
+package coda
+
+import (
+	"github.com/kmcqqq/pkg/config"
+	"testing"
+)
+
+func TestNewClientUrl(t *testing.T) {
+	const base = "https://coda.example.com/Payment"
+	cases := []struct {
+		name    string
+		cfg     config.CodaConfig
+		url     string
+		baseUrl string
+	}{
+		{"default", config.CodaConfig{}, DefaultUrl, DefaultBaseUrl},
+		{"init endpoint", config.CodaConfig{Url: base + "/init/"}, base + "/init/", base},
+		{"init endpoint without slash", config.CodaConfig{Url: base + "/init"}, base + "/init", base},
+		{"payment root", config.CodaConfig{Url: base + "/"}, base + "/init/", base},
+		{"base url only", config.CodaConfig{BaseUrl: base}, base + "/init/", base},
+		{"both", config.CodaConfig{Url: "https://proxy.example.com/coda/init/", BaseUrl: base + "/"}, "https://proxy.example.com/coda/init/", base},
+	}
+	for _, c := range cases {
+		cfg := c.cfg
+		clients, err := NewClient(&config.PayConfig{Coda: map[string]*config.CodaConfig{"id": &cfg}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := clients["id"]; got.Url != c.url || got.BaseUrl != c.baseUrl {
+			t.Errorf("%s: url = %s, base url = %s", c.name, got.Url, got.BaseUrl)
+		}
+	}
+}
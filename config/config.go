@@ -12,6 +12,7 @@ type Config struct {
 	Database   DatabaseConfig        `mapstructure:"database"`
 	Redis      ServerInfo            `mapstructure:"redis"`
 	RabbitMq   ServerInfo            `mapstructure:"rabbit-mq" json:"rabbitMq"`
+	Queue      QueueConfig           `mapstructure:"queue" json:"queue"`
 	Mongo      ServerInfo            `mapstructure:"mongo" json:"mongo"`
 	Log        LogConfig             `mapstructure:"log"`
 	RateLimit  RateLimitConfig       `mapstructure:"rate-limit" json:"rateLimit"`
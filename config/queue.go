@@ -0,0 +1,89 @@
+package config
+
+import "time"
+
+// QueueConfig 消息队列客户端配置，零值使用默认值
+type QueueConfig struct {
+	// Driver 队列后端：rabbitmq（默认）、redis（Redis Streams）或 memory
+	Driver string `mapstructure:"driver" json:"driver"`
+	// ReconnectDelay 断线后首次重连间隔，之后指数增长，默认 1s
+	ReconnectDelay time.Duration `mapstructure:"reconnect-delay" json:"reconnectDelay"`
+	// ReconnectMaxDelay 重连间隔上限，默认 30s
+	ReconnectMaxDelay time.Duration `mapstructure:"reconnect-max-delay" json:"reconnectMaxDelay"`
+	// PublishBuffer 断线期间缓存的待发送消息数，重连后补发；0 表示断线时直接返回错误
+	PublishBuffer int `mapstructure:"publish-buffer" json:"publishBuffer"`
+	// PublisherConfirm 开启后 PublishMessageByExchange/PublishDelayMessage 等待 broker 确认，nack 或无法路由返回错误
+	PublisherConfirm bool `mapstructure:"publisher-confirm" json:"publisherConfirm"`
+	// ConfirmTimeout 等待确认的超时，默认 5s
+	ConfirmTimeout time.Duration `mapstructure:"confirm-timeout" json:"confirmTimeout"`
+	// DelayStrategy 延迟消息实现：plugin（默认，需要 x-delayed-message 插件）或 ttl（按 DelayBuckets 分桶的 TTL 队列）
+	DelayStrategy string `mapstructure:"delay-strategy" json:"delayStrategy"`
+	// DelayBuckets ttl 策略的延迟档位，延迟向上取整到最近的档位，忽略非正值且至少保留一个，默认 1s/5s/10s/30s/1m/5m/10m/30m/1h
+	DelayBuckets []time.Duration `mapstructure:"delay-buckets" json:"delayBuckets"`
+	// Topology 连接后声明的拓扑，为空不声明
+	Topology *QueueTopology `mapstructure:"topology" json:"topology"`
+	// Redis Driver 为 redis 时的 Streams 配置
+	Redis RedisStreamConfig `mapstructure:"redis" json:"redis"`
+}
+
+// RedisStreamConfig Redis Streams 队列配置，零值使用默认值
+type RedisStreamConfig struct {
+	// Prefix stream 和延迟消息 key 的前缀，默认 queue:
+	Prefix string `mapstructure:"prefix" json:"prefix"`
+	// Group 消费组名，同组消费者竞争消费，默认 default
+	Group string `mapstructure:"group" json:"group"`
+	// Consumer 消费者名，默认 hostname-pid
+	Consumer string `mapstructure:"consumer" json:"consumer"`
+	// MaxLen stream 近似最大长度，同样作用于死信 stream，0 表示不裁剪
+	MaxLen int64 `mapstructure:"max-len" json:"maxLen"`
+	// Block XREADGROUP 阻塞时间，默认 2s
+	Block time.Duration `mapstructure:"block" json:"block"`
+	// ClaimIdle 未 ack 超过该时间的消息被重新认领处理，默认 1m
+	ClaimIdle time.Duration `mapstructure:"claim-idle" json:"claimIdle"`
+	// MaxDeliveries 投递次数达到后转入 <queue>.dlq stream，默认 5，小于 0 表示不限制
+	MaxDeliveries int64 `mapstructure:"max-deliveries" json:"maxDeliveries"`
+	// DelayPollInterval 延迟消息扫描间隔，默认 1s
+	DelayPollInterval time.Duration `mapstructure:"delay-poll-interval" json:"delayPollInterval"`
+}
+
+// QueueTopology 交换机、队列和绑定声明，RabbitMQ Init 及每次重连时幂等声明
+type QueueTopology struct {
+	Exchanges []ExchangeSpec `mapstructure:"exchanges" json:"exchanges"`
+	Queues    []QueueSpec    `mapstructure:"queues" json:"queues"`
+	Bindings  []BindingSpec  `mapstructure:"bindings" json:"bindings"`
+}
+
+// ExchangeSpec 交换机声明，Kind 为 direct/fanout/topic/headers 或 x-delayed-message
+type ExchangeSpec struct {
+	Name       string `mapstructure:"name" json:"name"`
+	Kind       string `mapstructure:"kind" json:"kind"`
+	Durable    bool   `mapstructure:"durable" json:"durable"`
+	AutoDelete bool   `mapstructure:"auto-delete" json:"autoDelete"`
+	// DelayedType x-delayed-message 交换机实际的路由类型，默认 direct
+	DelayedType string                 `mapstructure:"delayed-type" json:"delayedType"`
+	Args        map[string]interface{} `mapstructure:"args" json:"args"`
+}
+
+// QueueSpec 队列声明
+type QueueSpec struct {
+	Name       string `mapstructure:"name" json:"name"`
+	Durable    bool   `mapstructure:"durable" json:"durable"`
+	AutoDelete bool   `mapstructure:"auto-delete" json:"autoDelete"`
+	Exclusive  bool   `mapstructure:"exclusive" json:"exclusive"`
+	// MessageTTL 消息过期时间（x-message-ttl），0 表示不过期
+	MessageTTL time.Duration `mapstructure:"message-ttl" json:"messageTtl"`
+	// DeadLetterExchange 过期或被拒绝的消息转发的交换机（x-dead-letter-exchange）
+	DeadLetterExchange   string `mapstructure:"dead-letter-exchange" json:"deadLetterExchange"`
+	DeadLetterRoutingKey string `mapstructure:"dead-letter-routing-key" json:"deadLetterRoutingKey"`
+	// MaxLength 队列最大消息数（x-max-length），0 表示不限制
+	MaxLength int                    `mapstructure:"max-length" json:"maxLength"`
+	Args      map[string]interface{} `mapstructure:"args" json:"args"`
+}
+
+// BindingSpec 队列绑定
+type BindingSpec struct {
+	Queue      string                 `mapstructure:"queue" json:"queue"`
+	Exchange   string                 `mapstructure:"exchange" json:"exchange"`
+	RoutingKey string                 `mapstructure:"routing-key" json:"routingKey"`
+	Args       map[string]interface{} `mapstructure:"args" json:"args"`
+}
@@ -35,6 +35,9 @@ type XenditConfig struct {
 	PublicKey   string `mapstructure:"public-key"`
 	VerifyToken string `mapstructure:"verify-token"`
 	BusinessId  string `mapstructure:"business-id"`
+	Url         string `mapstructure:"url"`
+	// Sandbox 测试模式，只允许使用 xnd_development_ key
+	Sandbox bool `mapstructure:"sandbox"`
 }
 
 type PayerMaxConfig struct {
@@ -44,6 +47,7 @@ type PayerMaxConfig struct {
 	ReturnUrl       string `mapstructure:"return-url" json:"returnUrl"`
 	NotifyUrl       string `mapstructure:"notify-url" json:"notifyUrl"`
 	PayOutNotifyUrl string `mapstructure:"pay-out-notify-url" json:"payOutNotifyUrl"`
+	RefundNotifyUrl string `mapstructure:"refund-notify-url" json:"refundNotifyUrl"`
 	RSAPublicKey    string `json:"rsaPublicKey"`
 	RSAPrivateKey   string `json:"rsaPrivateKey"`
 	//RSAPublicBytes  []byte `mapstructure:"-" json:"-"`
@@ -51,10 +55,13 @@ type PayerMaxConfig struct {
 }
 
 type CodaConfig struct {
-	ApiKey   string `mapstructure:"api-key"`
-	Country  int    `mapstructure:"country"`
-	Currency int    `mapstructure:"currency"`
-	Url      string `mapstructure:"url"`
+	ApiKey      string `mapstructure:"api-key"`
+	Country     int    `mapstructure:"country"`
+	Currency    int    `mapstructure:"currency"`
+	Url         string `mapstructure:"url"`      // init 下单接口完整地址，为空时使用 coda.DefaultUrl；不以 /init 结尾时按 Payment 根路径处理
+	BaseUrl     string `mapstructure:"base-url"` // restful Payment 根路径，用于查询等接口，为空时由 Url 去掉 /init/ 得到
+	RedirectUrl string `mapstructure:"redirect-url"`
+	ItemCode    string `mapstructure:"item-code"`
 }
 
 type BinanceConfig struct {
@@ -0,0 +1,148 @@
+package httpHelper
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/webhook"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("httpHelper: circuit breaker is open")
+
+// BreakerConfig 按 host 熔断配置
+type BreakerConfig struct {
+	// FailureThreshold 连续失败（网络错误或 5xx）达到该次数后熔断
+	FailureThreshold int
+	// OpenTimeout 熔断持续时间，到期后放行一个探测请求
+	OpenTimeout time.Duration
+	// AlertWebhookURL 不为空时熔断状态变化通过 webhook 告警
+	AlertWebhookURL string
+	AlertPlatform   uint
+}
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type hostBreaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+type circuitBreaker struct {
+	cfg   BreakerConfig
+	mu    sync.Mutex
+	hosts map[string]*hostBreaker
+}
+
+func newCircuitBreaker(cfg *BreakerConfig) *circuitBreaker {
+	c := *cfg
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = 30 * time.Second
+	}
+	return &circuitBreaker{cfg: c, hosts: make(map[string]*hostBreaker)}
+}
+
+// allow 判断 host 当前是否放行请求
+func (cb *circuitBreaker) allow(host string) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	hb := cb.host(host)
+	switch hb.state {
+	case stateOpen:
+		if time.Since(hb.openedAt) < cb.cfg.OpenTimeout {
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		}
+		cb.transition(host, hb, stateHalfOpen)
+		hb.probing = true
+		return nil
+	case stateHalfOpen:
+		if hb.probing {
+			return fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+		}
+		hb.probing = true
+	}
+	return nil
+}
+
+// report 上报请求结果
+func (cb *circuitBreaker) report(host string, success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	hb := cb.host(host)
+	hb.probing = false
+	if success {
+		hb.failures = 0
+		if hb.state != stateClosed {
+			cb.transition(host, hb, stateClosed)
+		}
+		return
+	}
+
+	hb.failures++
+	if hb.state == stateHalfOpen || (hb.state == stateClosed && hb.failures >= cb.cfg.FailureThreshold) {
+		hb.openedAt = time.Now()
+		cb.transition(host, hb, stateOpen)
+	}
+}
+
+// release 请求被调用方取消，不计入结果，只释放探测名额
+func (cb *circuitBreaker) release(host string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.host(host).probing = false
+}
+
+func (cb *circuitBreaker) host(host string) *hostBreaker {
+	hb, ok := cb.hosts[host]
+	if !ok {
+		hb = &hostBreaker{}
+		cb.hosts[host] = hb
+	}
+	return hb
+}
+
+// transition 切换状态并记录日志、发送告警
+func (cb *circuitBreaker) transition(host string, hb *hostBreaker, to breakerState) {
+	from := hb.state
+	hb.state = to
+
+	logger.Warn("http breaker", logger.String("host", host), logger.String("from", from.String()), logger.String("to", to.String()), logger.Int("failures", hb.failures))
+
+	if cb.cfg.AlertWebhookURL == "" || to == stateHalfOpen {
+		return
+	}
+	message := webhook.Message{
+		Platform: cb.cfg.AlertPlatform,
+		Content:  fmt.Sprintf("[http breaker] %s: %s -> %s, consecutive failures %d", host, from, to, hb.failures),
+	}
+	go func(url string) {
+		if err := webhook.SendWebhook(message, url); err != nil {
+			logger.Error("error", logger.String("title", "http breaker alert failed"), logger.String("host", host), logger.Err(err))
+		}
+	}(cb.cfg.AlertWebhookURL)
+}
@@ -0,0 +1,358 @@
+package httpHelper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kmcqqq/pkg/logger"
+	"io"
+	"net/http"
+	"time"
+)
+
+const DefaultTimeout = 1 * time.Minute
+
+// sharedTransport 所有 Client 默认共用的连接池
+var sharedTransport = newTransport()
+
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 200
+	transport.MaxIdleConnsPerHost = 50
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
+// DefaultClient 包级函数使用的客户端
+var DefaultClient = NewClient(nil)
+
+// ClientConfig Client 配置，零值使用共享连接池和默认超时
+type ClientConfig struct {
+	// Timeout ctx 未设置 deadline 时的单次请求超时
+	Timeout time.Duration
+	// Transport 可注入 mock 或自定义的 RoundTripper
+	Transport http.RoundTripper
+	// Retry 为空不重试
+	Retry *RetryPolicy
+	// Breaker 为空不熔断
+	Breaker *BreakerConfig
+	// Redactor 日志脱敏规则，为空使用 DefaultRedactor
+	Redactor *Redactor
+	// Instrumenter 指标和链路追踪埋点，为空不埋点
+	Instrumenter Instrumenter
+}
+
+// Client 可复用的 http 客户端，所有请求都接收 context
+type Client struct {
+	client       *http.Client
+	timeout      time.Duration
+	retry        *RetryPolicy
+	breaker      *circuitBreaker
+	redactor     *Redactor
+	instrumenter Instrumenter
+}
+
+func NewClient(cfg *ClientConfig) *Client {
+	if cfg == nil {
+		cfg = &ClientConfig{}
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	transport := cfg.Transport
+	if transport == nil {
+		transport = sharedTransport
+	}
+
+	redactor := cfg.Redactor
+	if redactor == nil {
+		redactor = DefaultRedactor()
+	} else if redactor.headers == nil || redactor.fields == nil {
+		redactor = NewRedactor(redactor.Headers, redactor.Fields, redactor.MaxBodySize)
+	}
+
+	client := &Client{
+		client:       &http.Client{Transport: transport},
+		timeout:      timeout,
+		retry:        cfg.Retry,
+		redactor:     redactor,
+		instrumenter: cfg.Instrumenter,
+	}
+	if cfg.Breaker != nil {
+		client.breaker = newCircuitBreaker(cfg.Breaker)
+	}
+	return client
+}
+
+// StatusError 非 200 响应
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+// Response 读取完毕的响应
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+}
+
+// Do 发送请求并读取完整响应体，按配置重试和熔断；ctx 未设置 deadline 时每次尝试使用 Client 默认超时
+func (c *Client) Do(ctx context.Context, request *http.Request) (*Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	maxAttempts := 1
+	if c.retry != nil && c.retry.MaxAttempts > 1 && isIdempotent(request) && (request.Body == nil || request.GetBody != nil) {
+		maxAttempts = c.retry.MaxAttempts
+	}
+
+	host := request.URL.Host
+	for attempt := 1; ; attempt++ {
+		if c.breaker != nil {
+			if err := c.breaker.allow(host); err != nil {
+				return nil, err
+			}
+		}
+
+		res, err := c.roundTrip(ctx, request)
+
+		if c.breaker != nil {
+			if ctx.Err() != nil {
+				c.breaker.release(host)
+			} else {
+				c.breaker.report(host, err == nil && res.StatusCode < 500)
+			}
+		}
+
+		if attempt >= maxAttempts || !retryable(ctx, res, err) {
+			return res, err
+		}
+
+		delay := c.retry.backoff(attempt, res)
+		logger.Warn("http retry", logger.String("url", c.redactor.URL(request.URL.String())), logger.String("method", request.Method), logger.Int("attempt", attempt), logger.Duration("delay", delay), logger.String("reason", retryReason(res, err)))
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			request.Body = body
+		}
+	}
+}
+
+// roundTrip 单次请求，配置了 Instrumenter 时记录耗时和结果
+func (c *Client) roundTrip(ctx context.Context, request *http.Request) (*Response, error) {
+	if c.instrumenter == nil {
+		return c.send(ctx, request)
+	}
+
+	ctx = c.instrumenter.Before(ctx, request)
+	start := time.Now()
+	res, err := c.send(ctx, request)
+	c.instrumenter.After(ctx, request, res, err, time.Since(start))
+	return res, err
+}
+
+func (c *Client) send(ctx context.Context, request *http.Request) (*Response, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	response, err := c.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Response{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Header:     response.Header,
+		Body:       body,
+	}, nil
+}
+
+func retryReason(res *Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return res.Status
+}
+
+// Send 构造请求并发送，记录请求日志
+func (c *Client) Send(ctx context.Context, method, url string, body []byte, header http.Header) (*Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	request, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		request.Header = header
+	}
+
+	res, err := c.Do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("http", logger.String("url", c.redactor.URL(url)), logger.String("method", method), logger.Int("StatusCode", res.StatusCode), logger.String("req", c.redactor.Body(body)), logger.String("header", c.redactor.Header(header)), logger.String("resp", c.redactor.Body(res.Body)))
+
+	return res, nil
+}
+
+// Post 请求
+func (c *Client) Post(ctx context.Context, url string, data interface{}, header map[string]string) (string, error) {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	h := toHeader(header)
+	h.Set("Content-Type", "application/json;charset=utf-8")
+
+	res, err := c.Send(ctx, http.MethodPost, url, dataBytes, h)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", &StatusError{StatusCode: res.StatusCode, Status: res.Status, Body: res.Body}
+	}
+
+	return string(res.Body), nil
+}
+
+// GetHeader Get带 header 请求
+func (c *Client) GetHeader(ctx context.Context, url string, header map[string]string) (string, error) {
+	res, err := c.Send(ctx, http.MethodGet, url, nil, toHeader(header))
+	if err != nil {
+		return "", err
+	}
+	return string(res.Body), nil
+}
+
+// Get 请求
+func (c *Client) Get(ctx context.Context, url string) (string, error) {
+	return c.GetHeader(ctx, url, nil)
+}
+
+// GetBytes GET 请求，返回状态码、header 和原始响应体，非 200 不视为错误
+func (c *Client) GetBytes(ctx context.Context, url string, header map[string]string) (*Response, error) {
+	return c.Send(ctx, http.MethodGet, url, nil, toHeader(header))
+}
+
+// PostBytes POST 原始请求体，contentType 为空使用 application/octet-stream，非 200 不视为错误
+func (c *Client) PostBytes(ctx context.Context, url, contentType string, body []byte, header map[string]string) (*Response, error) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h := toHeader(header)
+	h.Set("Content-Type", contentType)
+	return c.Send(ctx, http.MethodPost, url, body, h)
+}
+
+// HttpTransform http转发
+func (c *Client) HttpTransform(ctx context.Context, url, method string, body io.Reader, header http.Header) (string, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = io.ReadAll(body); err != nil {
+			return "", err
+		}
+	}
+
+	res, err := c.Send(ctx, method, url, data, header)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", &StatusError{StatusCode: res.StatusCode, Status: res.Status, Body: res.Body}
+	}
+
+	return string(res.Body), nil
+}
+
+// PostForm 表单请求，data 为 url 编码后的字符串
+func (c *Client) PostForm(ctx context.Context, url string, data string) (string, error) {
+	h := http.Header{}
+	h.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.Send(ctx, http.MethodPost, url, []byte(data), h)
+	if err != nil {
+		return "", err
+	}
+	return string(res.Body), nil
+}
+
+// DoJSON 发送 json 请求并将响应解析为 T，data 为 nil 时不带请求体
+func DoJSON[T any](ctx context.Context, c *Client, method, url string, data interface{}, header map[string]string) (*T, error) {
+	if c == nil {
+		c = DefaultClient
+	}
+
+	var body []byte
+	h := toHeader(header)
+	if data != nil {
+		var err error
+		if body, err = json.Marshal(data); err != nil {
+			return nil, err
+		}
+		h.Set("Content-Type", "application/json;charset=utf-8")
+	}
+
+	res, err := c.Send(ctx, method, url, body, h)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: res.StatusCode, Status: res.Status, Body: res.Body}
+	}
+
+	var result T
+	if err := json.Unmarshal(res.Body, &result); err != nil {
+		return nil, fmt.Errorf("decode %s response: %w", url, err)
+	}
+	return &result, nil
+}
+
+// PostJSON POST json 并解析响应
+func PostJSON[T any](ctx context.Context, c *Client, url string, data interface{}, header map[string]string) (*T, error) {
+	return DoJSON[T](ctx, c, http.MethodPost, url, data, header)
+}
+
+// GetJSON GET 并解析响应
+func GetJSON[T any](ctx context.Context, c *Client, url string, header map[string]string) (*T, error) {
+	return DoJSON[T](ctx, c, http.MethodGet, url, nil, header)
+}
+
+func toHeader(header map[string]string) http.Header {
+	h := make(http.Header, len(header))
+	for key, val := range header {
+		h.Add(key, val)
+	}
+	return h
+}
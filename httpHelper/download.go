@@ -0,0 +1,118 @@
+package httpHelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/logger"
+	"io"
+	"net/http"
+	"time"
+)
+
+var ErrBodyTooLarge = errors.New("httpHelper: response body exceeds size limit")
+
+// errorBodyLimit 非 200 下载响应最多读取的响应体，用于 StatusError
+const errorBodyLimit = 4096
+
+// DownloadResult 下载结果，Size 为已写入 io.Writer 的字节数
+type DownloadResult struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Size       int64
+}
+
+// Download GET 并将响应体流式写入 w，maxSize 大于 0 时超过限制返回 ErrBodyTooLarge；
+// 写入可能已部分完成，因此下载不重试，ctx 未设置 deadline 时整个下载使用 Client 默认超时
+func (c *Client) Download(ctx context.Context, url string, w io.Writer, maxSize int64, header map[string]string) (*DownloadResult, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header = toHeader(header)
+
+	host := request.URL.Host
+	if c.breaker != nil {
+		if err := c.breaker.allow(host); err != nil {
+			return nil, err
+		}
+	}
+
+	var start time.Time
+	if c.instrumenter != nil {
+		ctx = c.instrumenter.Before(ctx, request)
+		start = time.Now()
+	}
+
+	result, err := c.download(ctx, request, w, maxSize)
+
+	if c.instrumenter != nil {
+		// 收到响应即按状态码记录，大小超限等本地错误不计为请求失败
+		if result != nil {
+			c.instrumenter.After(ctx, request, &Response{StatusCode: result.StatusCode, Status: result.Status, Header: result.Header}, nil, time.Since(start))
+		} else {
+			c.instrumenter.After(ctx, request, nil, err, time.Since(start))
+		}
+	}
+	if c.breaker != nil {
+		if ctx.Err() != nil {
+			c.breaker.release(host)
+		} else {
+			c.breaker.report(host, result != nil && result.StatusCode < 500)
+		}
+	}
+
+	if result != nil {
+		logger.Info("http download", logger.String("url", c.redactor.URL(url)), logger.Int("StatusCode", result.StatusCode), logger.Int64("size", result.Size), logger.String("header", c.redactor.Header(request.Header)))
+	}
+	return result, err
+}
+
+// download 单次下载，收到响应后 result 不为空
+func (c *Client) download(ctx context.Context, request *http.Request, w io.Writer, maxSize int64) (*DownloadResult, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	response, err := c.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	result := &DownloadResult{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Header:     response.Header,
+	}
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, errorBodyLimit))
+		return result, &StatusError{StatusCode: response.StatusCode, Status: response.Status, Body: body}
+	}
+	if maxSize > 0 && response.ContentLength > maxSize {
+		return result, fmt.Errorf("%w: content-length %d > %d", ErrBodyTooLarge, response.ContentLength, maxSize)
+	}
+
+	if maxSize <= 0 {
+		result.Size, err = io.Copy(w, response.Body)
+		return result, err
+	}
+
+	result.Size, err = io.Copy(w, io.LimitReader(response.Body, maxSize))
+	if err != nil {
+		return result, err
+	}
+	// 再多读一个字节判断是否超出限制
+	if n, _ := io.CopyN(io.Discard, response.Body, 1); n > 0 {
+		return result, fmt.Errorf("%w: %d", ErrBodyTooLarge, maxSize)
+	}
+	return result, nil
+}
@@ -0,0 +1,29 @@
+package httpHelper
+
+import "context"
+
+// ClientHolder 嵌入第三方客户端，提供可替换的 HttpClient 和 WithContext 绑定的 ctx。
+// 嵌入方的 WithContext 需返回自身类型，拷贝后替换 ClientHolder 即可
+type ClientHolder struct {
+	// HttpClient 为空使用 DefaultClient
+	HttpClient *Client
+	ctx        context.Context
+}
+
+// WithContext 返回绑定 ctx 的副本
+func (h ClientHolder) WithContext(ctx context.Context) ClientHolder {
+	h.ctx = ctx
+	return h
+}
+
+// Client 返回请求使用的 ctx 和 Client，未绑定 ctx 时使用 context.Background()
+func (h ClientHolder) Client() (context.Context, *Client) {
+	ctx := h.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if h.HttpClient == nil {
+		return ctx, DefaultClient
+	}
+	return ctx, h.HttpClient
+}
@@ -1,176 +1,46 @@
 package httpHelper
 
 import (
-	"bytes"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"github.com/kmcqqq/pkg/logger"
+	"context"
 	"io"
 	"net/http"
-	"time"
 )
 
 // Post 请求
 func Post(url string, data interface{}, header map[string]string) (string, error) {
-	dataBytes, err := json.Marshal(data)
-	if err != nil {
-		return "", err
-	}
-
-	client := &http.Client{}
-
-	request, err := http.NewRequest("POST", url, bytes.NewReader(dataBytes))
-
-	if err != nil {
-		return "", err
-	}
-
-	request.Header.Add("Content-Type", "application/json;charset=utf-8")
-	for key, val := range header {
-		request.Header.Add(key, val)
-	}
-	client.Timeout = 1 * time.Minute
-
-	response, err := client.Do(request)
-	if err != nil {
-		return "", err
-	}
-
-	defer response.Body.Close()
-
-	bodyBytes, err := io.ReadAll(response.Body)
-
-	if err != nil {
-		return "", err
-	}
-
-	logger.Info("http", logger.String("url", url), logger.String("method", "POST"), logger.Int("StatusCode", response.StatusCode), logger.String("req", string(dataBytes)), logger.String("header", fmt.Sprintf("%+v", header)), logger.String("resp", string(bodyBytes)))
-
-	if response.StatusCode != 200 {
-		return "", errors.New(response.Status)
-	}
-
-	return string(bodyBytes), nil
+	return DefaultClient.Post(context.Background(), url, data, header)
 }
 
 // GetHeader Get带 header 请求
 func GetHeader(url string, header map[string]string) (string, error) {
-	client := &http.Client{}
-	request, err := http.NewRequest("GET", url, nil)
-
-	if err != nil {
-		return "", err
-	}
-
-	for key, val := range header {
-		request.Header.Add(key, val)
-	}
-	client.Timeout = 1 * time.Minute
-
-	resp, err := client.Do(request)
-	if err != nil {
-		return "", err
-	}
-
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	//zap.S().Infow("httpHelper", "url", url, "method", "GET", "header", "StatusCode", resp.StatusCode, fmt.Sprintf("%+v", header), "resp", string(body))
-	logger.Info("http", logger.String("url", url), logger.String("method", "GET"), logger.Int("StatusCode", resp.StatusCode), logger.String("header", fmt.Sprintf("%+v", header)), logger.String("resp", string(body)))
-
-	return string(body), nil
+	return DefaultClient.GetHeader(context.Background(), url, header)
 }
 
 // Get 请求
 func Get(url string) (string, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	//zap.S().Infow("httpHelper", "url", url, "method", "GET", "StatusCode", resp.StatusCode, "resp", string(body))
-	logger.Info("http", logger.String("url", url), logger.String("method", "GET"), logger.Int("StatusCode", resp.StatusCode), logger.String("resp", string(body)))
-
-	return string(body), nil
+	return DefaultClient.Get(context.Background(), url)
 }
 
 // http转发
 func HttpTransform(url, method string, body io.Reader, header http.Header) (string, error) {
-	client := &http.Client{}
-	request, err := http.NewRequest(method, url, body)
-
-	if err != nil {
-		return "", err
-	}
-
-	request.Header = header
-
-	client.Timeout = 1 * time.Minute
-
-	response, err := client.Do(request)
-	if err != nil {
-		return "", err
-	}
-
-	defer response.Body.Close()
-
-	bodyBytes, err := io.ReadAll(response.Body)
-
-	if err != nil {
-		return "", err
-	}
-
-	data, _ := io.ReadAll(body)
-	//zap.S().Infow("httpHelper", "url", url, "method", method, "StatusCode", response.StatusCode, "req", string(data), "header", fmt.Sprintf("%+v", header), "resp", string(bodyBytes))
-	logger.Info("http", logger.String("url", url), logger.String("method", method), logger.Int("StatusCode", response.StatusCode), logger.String("req", string(data)), logger.String("header", fmt.Sprintf("%+v", header)), logger.String("resp", string(bodyBytes)))
-
-	if response.StatusCode != 200 {
-		return "", errors.New(response.Status)
-	}
-
-	return string(bodyBytes), nil
+	return DefaultClient.HttpTransform(context.Background(), url, method, body, header)
 }
 
 func PostForm(url string, data string) (string, error) {
-	//fmt.Printf("request url %s, data : %+v", url, data)
-
-	dataBytes := []byte(data)
-
-	client := &http.Client{}
-
-	request, err := http.NewRequest("POST", url, bytes.NewReader(dataBytes))
-
-	if err != nil {
-		return "", err
-	}
-
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-
-	client.Timeout = 1 * time.Minute
-
-	response, err := client.Do(request)
-
-	defer response.Body.Close()
-
-	bodyBytes, err := io.ReadAll(response.Body)
+	return DefaultClient.PostForm(context.Background(), url, data)
+}
 
-	if err != nil {
-		return "", err
-	}
+// GetBytes 返回原始响应
+func GetBytes(url string, header map[string]string) (*Response, error) {
+	return DefaultClient.GetBytes(context.Background(), url, header)
+}
 
-	//fmt.Printf("response data : %+v", string(bodyBytes))
-	//zap.S().Infow("httpHelper", "url", url, "method", "POST", "req", string(dataBytes), "resp", string(bodyBytes))
+// PostMultipart multipart/form-data 上传
+func PostMultipart(url string, fields map[string]string, files []File, header map[string]string) (*Response, error) {
+	return DefaultClient.PostMultipart(context.Background(), url, fields, files, header)
+}
 
-	return string(bodyBytes), nil
+// Download 流式下载到 w
+func Download(url string, w io.Writer, maxSize int64, header map[string]string) (*DownloadResult, error) {
+	return DefaultClient.Download(context.Background(), url, w, maxSize, header)
 }
@@ -0,0 +1,35 @@
+package httpHelper
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Instrumenter 出站请求埋点，每次尝试（含重试）各调用一次 Before/After
+type Instrumenter interface {
+	// Before 发送前调用，返回的 ctx 用于本次尝试并传给 After，可在 request.Header 中注入 traceparent
+	Before(ctx context.Context, request *http.Request) context.Context
+	// After 本次尝试结束后调用，err 不为空时 res 为 nil
+	After(ctx context.Context, request *http.Request, res *Response, err error, elapsed time.Duration)
+}
+
+// Instrumenters 组合多个 Instrumenter，Before 顺序调用，After 逆序调用
+func Instrumenters(instrumenters ...Instrumenter) Instrumenter {
+	return chainInstrumenter(instrumenters)
+}
+
+type chainInstrumenter []Instrumenter
+
+func (c chainInstrumenter) Before(ctx context.Context, request *http.Request) context.Context {
+	for _, instrumenter := range c {
+		ctx = instrumenter.Before(ctx, request)
+	}
+	return ctx
+}
+
+func (c chainInstrumenter) After(ctx context.Context, request *http.Request, res *Response, err error, elapsed time.Duration) {
+	for i := len(c) - 1; i >= 0; i-- {
+		c[i].After(ctx, request, res, err, elapsed)
+	}
+}
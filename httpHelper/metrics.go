@@ -0,0 +1,189 @@
+package httpHelper
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DefaultLatencyBuckets 延迟直方图默认分桶上界
+var DefaultLatencyBuckets = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// MetricKey 指标维度，Path 经过 PathNormalizer 处理
+type MetricKey struct {
+	Host   string
+	Method string
+	Path   string
+}
+
+// MetricSnapshot 某个维度的指标快照
+type MetricSnapshot struct {
+	MetricKey
+	Count int64
+	Sum   time.Duration
+	// Buckets 与 Metrics 分桶一一对应的累计计数，最后一个为 +Inf
+	Buckets []int64
+	// Status 按状态码计数，网络错误不计入
+	Status map[int]int64
+	Errors int64
+}
+
+// Metrics 内存中的延迟直方图和状态码计数，实现 Instrumenter，可定期 Snapshot 导出到监控系统
+type Metrics struct {
+	// PathNormalizer 归一化路径，避免订单号等 id 造成维度爆炸，为空使用 NormalizePath
+	PathNormalizer func(path string) string
+
+	buckets []time.Duration
+	mu      sync.Mutex
+	series  map[MetricKey]*metricSeries
+}
+
+type metricSeries struct {
+	count   int64
+	sum     time.Duration
+	buckets []int64
+	status  map[int]int64
+	errors  int64
+}
+
+var _ Instrumenter = &Metrics{}
+
+// NewMetrics 创建指标收集器，buckets 为空使用 DefaultLatencyBuckets
+func NewMetrics(buckets []time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	sorted := make([]time.Duration, len(buckets))
+	copy(sorted, buckets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return &Metrics{
+		buckets: sorted,
+		series:  make(map[MetricKey]*metricSeries),
+	}
+}
+
+// Buckets 分桶上界
+func (m *Metrics) Buckets() []time.Duration {
+	return m.buckets
+}
+
+func (m *Metrics) Before(ctx context.Context, request *http.Request) context.Context {
+	return ctx
+}
+
+func (m *Metrics) After(ctx context.Context, request *http.Request, res *Response, err error, elapsed time.Duration) {
+	normalize := m.PathNormalizer
+	if normalize == nil {
+		normalize = NormalizePath
+	}
+	key := MetricKey{Host: request.URL.Host, Method: request.Method, Path: normalize(request.URL.Path)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{buckets: make([]int64, len(m.buckets)+1), status: make(map[int]int64)}
+		m.series[key] = s
+	}
+
+	s.count++
+	s.sum += elapsed
+	i := sort.Search(len(m.buckets), func(i int) bool { return elapsed <= m.buckets[i] })
+	s.buckets[i]++
+	if err != nil {
+		s.errors++
+	} else {
+		s.status[res.StatusCode]++
+	}
+}
+
+// Snapshot 当前所有维度的指标，Buckets 为累计值
+func (m *Metrics) Snapshot() []MetricSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshots := make([]MetricSnapshot, 0, len(m.series))
+	for key, s := range m.series {
+		snapshot := MetricSnapshot{
+			MetricKey: key,
+			Count:     s.count,
+			Sum:       s.sum,
+			Buckets:   make([]int64, len(s.buckets)),
+			Status:    make(map[int]int64, len(s.status)),
+			Errors:    s.errors,
+		}
+		var cumulative int64
+		for i, n := range s.buckets {
+			cumulative += n
+			snapshot.Buckets[i] = cumulative
+		}
+		for code, n := range s.status {
+			snapshot.Status[code] = n
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		a, b := snapshots[i].MetricKey, snapshots[j].MetricKey
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Method < b.Method
+	})
+	return snapshots
+}
+
+// Reset 清空已收集的指标
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series = make(map[MetricKey]*metricSeries)
+}
+
+// NormalizePath 将纯数字或较长且含数字的路径段替换为 {id}，如 /v2/payouts/disb-8f3a... => /v2/payouts/{id}
+func NormalizePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isIdSegment(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isIdSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+
+	digits := 0
+	for _, r := range segment {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	return digits == len(segment) || (digits > 0 && len(segment) >= 16)
+}
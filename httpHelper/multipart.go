@@ -0,0 +1,91 @@
+package httpHelper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/kmcqqq/pkg/logger"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// File multipart 上传的文件
+type File struct {
+	// FieldName 表单字段名
+	FieldName string
+	FileName  string
+	// ContentType 为空使用 application/octet-stream
+	ContentType string
+	Reader      io.Reader
+}
+
+// PostMultipart multipart/form-data 上传文件和普通字段，请求体在内存中构造，带 Idempotency-Key 时可重试
+func (c *Client) PostMultipart(ctx context.Context, url string, fields map[string]string, files []File, header map[string]string) (*Response, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	for key, val := range fields {
+		if err := writer.WriteField(key, val); err != nil {
+			return nil, err
+		}
+	}
+
+	fileSummary := make([]string, 0, len(files))
+	for _, file := range files {
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(file.FieldName), escapeQuotes(file.FileName)))
+		h.Set("Content-Type", contentType)
+		part, err := writer.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		n, err := io.Copy(part, file.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("read file %s: %w", file.FileName, err)
+		}
+		fileSummary = append(fileSummary, fmt.Sprintf("%s=%s(%d bytes)", file.FieldName, file.FileName, n))
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header = toHeader(header)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+
+	res, err := c.Do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info("http", logger.String("url", c.redactor.URL(url)), logger.String("method", http.MethodPost), logger.Int("StatusCode", res.StatusCode), logger.String("req", c.redactor.Body([]byte(encodeFields(fields)))), logger.String("files", strings.Join(fileSummary, ",")), logger.String("header", c.redactor.Header(request.Header)), logger.String("resp", c.redactor.Body(res.Body)))
+
+	return res, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// encodeFields 普通字段按 form 编码后用于日志脱敏
+func encodeFields(fields map[string]string) string {
+	values := make(url.Values, len(fields))
+	for key, val := range fields {
+		values.Set(key, val)
+	}
+	return values.Encode()
+}
@@ -0,0 +1,359 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/httpHelper"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Mode 录制/回放模式
+type Mode int
+
+const (
+	// ModeReplay 只从 golden 文件回放，未匹配的请求返回错误
+	ModeReplay Mode = iota
+	// ModeRecord 请求真实接口并录制，Save 时覆盖 golden 文件
+	ModeRecord
+	// ModeReplayOrRecord golden 文件存在时回放，否则录制
+	ModeReplayOrRecord
+)
+
+var ErrNoInteraction = errors.New("recorder: no matching interaction")
+
+// DefaultIgnoreFields 每次请求都会变化、匹配时忽略的 body 字段
+var DefaultIgnoreFields = []string{"requestTime", "timestamp", "nonce"}
+
+// DefaultDropHeaders 录制时不写入 golden 文件的 header，避免泄露密钥
+var DefaultDropHeaders = []string{
+	"Authorization", "sign", "X-Callback-Token",
+	"BinancePay-Signature", "BinancePay-Nonce", "BinancePay-Timestamp", "BinancePay-Certificate-SN",
+}
+
+// Interaction 一次请求/响应
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Matcher 判断请求是否与录制记录匹配，r 的 url 和 body 均已按 Redactor 脱敏
+type Matcher func(r *http.Request, body []byte, recorded *RecordedRequest) bool
+
+// Config 录制配置，零值为回放模式并使用默认匹配规则
+type Config struct {
+	Mode Mode
+	// Transport 录制时使用的真实 RoundTripper，为空使用 http.DefaultTransport
+	Transport http.RoundTripper
+	// Matcher 为空使用 DefaultMatcher(IgnoreFields)
+	Matcher Matcher
+	// IgnoreFields 匹配时忽略的 json 字段或 form/query 参数，为空使用 DefaultIgnoreFields
+	IgnoreFields []string
+	// DropHeaders 录制时丢弃的 header，为空使用 DefaultDropHeaders
+	DropHeaders []string
+	// Redactor 录制前对 url 和请求/响应 body 脱敏，回放时按脱敏后的请求匹配，为空使用 httpHelper.DefaultRedactor 的字段且不截断
+	Redactor *httpHelper.Redactor
+}
+
+// Recorder 可注入 httpHelper.ClientConfig.Transport 的录制/回放 RoundTripper
+type Recorder struct {
+	path         string
+	recording    bool
+	transport    http.RoundTripper
+	matcher      Matcher
+	dropHeaders  []string
+	redactor     *httpHelper.Redactor
+	mu           sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+var _ http.RoundTripper = &Recorder{}
+
+// New 创建 Recorder，path 为 golden 文件路径
+func New(path string, cfg *Config) (*Recorder, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	r := &Recorder{
+		path:        path,
+		transport:   cfg.Transport,
+		matcher:     cfg.Matcher,
+		dropHeaders: cfg.DropHeaders,
+		redactor:    cfg.Redactor,
+	}
+	if r.transport == nil {
+		r.transport = http.DefaultTransport
+	}
+	if r.matcher == nil {
+		ignore := cfg.IgnoreFields
+		if ignore == nil {
+			ignore = DefaultIgnoreFields
+		}
+		r.matcher = DefaultMatcher(ignore...)
+	}
+	if r.dropHeaders == nil {
+		r.dropHeaders = DefaultDropHeaders
+	}
+	if r.redactor == nil {
+		def := httpHelper.DefaultRedactor()
+		r.redactor = httpHelper.NewRedactor(def.Headers, def.Fields, 0)
+	}
+
+	switch cfg.Mode {
+	case ModeRecord:
+		r.recording = true
+	case ModeReplayOrRecord:
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			r.recording = true
+		}
+	}
+
+	if !r.recording {
+		if err := r.load(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Recording 当前是否处于录制状态
+func (r *Recorder) Recording() bool {
+	return r.recording
+}
+
+func (r *Recorder) load() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("recorder: read golden file: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.interactions); err != nil {
+		return fmt.Errorf("recorder: decode golden file %s: %w", r.path, err)
+	}
+	r.used = make([]bool, len(r.interactions))
+	return nil
+}
+
+// RoundTrip 录制模式转发并记录，回放模式按顺序返回第一个未使用的匹配记录
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if r.recording {
+		return r.record(req, body)
+	}
+	return r.replay(req, body)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte) (*http.Response, error) {
+	res, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := &Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    r.redactor.URL(req.URL.String()),
+			Header: r.filterHeader(req.Header),
+			Body:   r.redactor.Body(body),
+		},
+		Response: RecordedResponse{
+			StatusCode: res.StatusCode,
+			Header:     r.filterHeader(res.Header),
+			Body:       r.redactor.Body(resBody),
+		},
+	}
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, interaction)
+	r.used = append(r.used, true)
+	r.mu.Unlock()
+
+	// 录制时调用方拿到的是未脱敏的真实响应
+	return buildResponse(req, &RecordedResponse{StatusCode: res.StatusCode, Header: res.Header, Body: string(resBody)}), nil
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	// golden 文件中保存的是脱敏后的请求，按同样规则脱敏后再匹配
+	redacted := *req
+	if u, err := url.Parse(r.redactor.URL(req.URL.String())); err == nil {
+		redacted.URL = u
+	}
+	redactedBody := []byte(r.redactor.Body(body))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.interactions {
+		if r.used[i] || !r.matcher(&redacted, redactedBody, &interaction.Request) {
+			continue
+		}
+		r.used[i] = true
+		return buildResponse(req, &interaction.Response), nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL)
+}
+
+// Save 录制模式下写入 golden 文件，回放模式不做任何操作
+func (r *Recorder) Save() error {
+	if !r.recording {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data, 0644)
+}
+
+// Unused 回放模式下尚未被请求的记录数，可用于断言调用完整
+func (r *Recorder) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, used := range r.used {
+		if !used {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *Recorder) filterHeader(header http.Header) http.Header {
+	filtered := header.Clone()
+	for _, name := range r.dropHeaders {
+		filtered.Del(name)
+	}
+	return filtered
+}
+
+func buildResponse(req *http.Request, recorded *RecordedResponse) *http.Response {
+	header := recorded.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode:    recorded.StatusCode,
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}
+}
+
+// DefaultMatcher 比较 method、url 和 body，忽略 ignoreFields 中的字段（任意层级）
+func DefaultMatcher(ignoreFields ...string) Matcher {
+	ignore := make(map[string]struct{}, len(ignoreFields))
+	for _, f := range ignoreFields {
+		ignore[f] = struct{}{}
+	}
+
+	return func(r *http.Request, body []byte, recorded *RecordedRequest) bool {
+		if r.Method != recorded.Method {
+			return false
+		}
+		recordedUrl, err := url.Parse(recorded.URL)
+		if err != nil {
+			return false
+		}
+		if r.URL.Scheme != recordedUrl.Scheme || r.URL.Host != recordedUrl.Host || r.URL.Path != recordedUrl.Path {
+			return false
+		}
+		if !reflect.DeepEqual(stripValues(r.URL.Query(), ignore), stripValues(recordedUrl.Query(), ignore)) {
+			return false
+		}
+		return bodyEqual(body, []byte(recorded.Body), ignore)
+	}
+}
+
+func bodyEqual(a, b []byte, ignore map[string]struct{}) bool {
+	var ja, jb interface{}
+	if json.Unmarshal(a, &ja) == nil && json.Unmarshal(b, &jb) == nil {
+		return reflect.DeepEqual(stripJSON(ja, ignore), stripJSON(jb, ignore))
+	}
+
+	if bytes.IndexByte(a, '=') > 0 && bytes.IndexByte(b, '=') > 0 {
+		qa, errA := url.ParseQuery(string(a))
+		qb, errB := url.ParseQuery(string(b))
+		if errA == nil && errB == nil {
+			return reflect.DeepEqual(stripValues(qa, ignore), stripValues(qb, ignore))
+		}
+	}
+
+	return bytes.Equal(a, b)
+}
+
+func stripValues(values url.Values, ignore map[string]struct{}) url.Values {
+	for key := range values {
+		if _, ok := ignore[key]; ok {
+			delete(values, key)
+		}
+	}
+	return values
+}
+
+func stripJSON(data interface{}, ignore map[string]struct{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			if _, ok := ignore[k]; ok {
+				delete(value, k)
+				continue
+			}
+			value[k] = stripJSON(v, ignore)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = stripJSON(item, ignore)
+		}
+	}
+	return data
+}
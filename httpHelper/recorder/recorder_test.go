@@ -0,0 +1,95 @@
+package recorder
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func post(t *testing.T, client *http.Client, url, body string) (string, error) {
+	t.Helper()
+	res, err := client.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), nil
+}
+
+func TestRecordSaveReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"code":"SUCCESS","access_token":"live-token","orderId":"o-1"}`)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "golden.json")
+	rec, err := New(path, &Config{Mode: ModeRecord})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rec}
+
+	body, err := post(t, client, server.URL+"/pay?apiKey=live-key&x=1", `{"orderId":"o-1","client_secret":"live-secret","requestTime":"t1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "live-token") {
+		t.Fatalf("recording should return the real response, got %s", body)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"live-secret", "live-key", "live-token"} {
+		if strings.Contains(string(golden), secret) {
+			t.Fatalf("golden file contains %q:\n%s", secret, golden)
+		}
+	}
+
+	replay, err := New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: replay}
+
+	// 非忽略字段不同不匹配
+	if _, err := post(t, client, server.URL+"/pay?apiKey=live-key&x=1", `{"orderId":"o-2","client_secret":"live-secret","requestTime":"t1"}`); !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("expected ErrNoInteraction, got %v", err)
+	}
+
+	// requestTime 被忽略，密钥变化不影响匹配
+	body, err = post(t, client, server.URL+"/pay?apiKey=other-key&x=1", `{"orderId":"o-1","client_secret":"other-secret","requestTime":"t2"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"orderId":"o-1"`) || strings.Contains(body, "live-token") {
+		t.Fatalf("unexpected replay body %s", body)
+	}
+	if calls != 1 {
+		t.Fatalf("replay should not hit the server, calls = %d", calls)
+	}
+	if n := replay.Unused(); n != 0 {
+		t.Fatalf("unused = %d", n)
+	}
+
+	// 每条记录只回放一次
+	if _, err := post(t, client, server.URL+"/pay?apiKey=live-key&x=1", `{"orderId":"o-1","client_secret":"live-secret"}`); !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("expected ErrNoInteraction, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package httpHelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redactedValue = "REDACTED"
+
+// Redactor 请求/响应日志脱敏，名称匹配不区分大小写
+type Redactor struct {
+	// Headers 需要脱敏的 header
+	Headers []string
+	// Fields 需要脱敏的 json 字段或 form/query 参数，任意层级都会匹配
+	Fields []string
+	// MaxBodySize 日志中 body 的最大字节数，小于等于 0 不截断
+	MaxBodySize int
+
+	headers map[string]struct{}
+	fields  map[string]struct{}
+}
+
+// DefaultRedactor 默认脱敏规则，覆盖鉴权 header、第三方密钥、签名和收款账户
+func DefaultRedactor() *Redactor {
+	return NewRedactor(
+		[]string{
+			"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+			"sign", "X-Callback-Token", "BinancePay-Signature", "BinancePay-Certificate-SN",
+		},
+		[]string{
+			"client_secret", "password", "access_token", "token", "apiKey", "api_key", "secret_key", "secretKey",
+			"sign", "signature", "checksum",
+			"accountNo", "account_number", "account_holder_name", "fullName", "payeePhone", "receiver", "cardNo",
+		},
+		4096,
+	)
+}
+
+func NewRedactor(headers, fields []string, maxBodySize int) *Redactor {
+	r := &Redactor{
+		Headers:     headers,
+		Fields:      fields,
+		MaxBodySize: maxBodySize,
+		headers:     make(map[string]struct{}, len(headers)),
+		fields:      make(map[string]struct{}, len(fields)),
+	}
+	for _, h := range headers {
+		r.headers[strings.ToLower(h)] = struct{}{}
+	}
+	for _, f := range fields {
+		r.fields[strings.ToLower(f)] = struct{}{}
+	}
+	return r
+}
+
+func (r *Redactor) isHeader(name string) bool {
+	_, ok := r.headers[strings.ToLower(name)]
+	return ok
+}
+
+func (r *Redactor) isField(name string) bool {
+	_, ok := r.fields[strings.ToLower(name)]
+	return ok
+}
+
+// Header 脱敏后的 header 字符串
+func (r *Redactor) Header(header http.Header) string {
+	if len(header) == 0 {
+		return "map[]"
+	}
+	masked := make(http.Header, len(header))
+	for key, values := range header {
+		if r.isHeader(key) {
+			masked[key] = []string{redactedValue}
+			continue
+		}
+		masked[key] = values
+	}
+	return fmt.Sprintf("%+v", masked)
+}
+
+// URL 脱敏 query 参数
+func (r *Redactor) URL(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.RawQuery == "" {
+		return rawUrl
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return rawUrl
+	}
+	u.RawQuery = r.maskValues(query).Encode()
+	return u.String()
+}
+
+// Body 脱敏 json 或 form 格式的 body 并截断
+func (r *Redactor) Body(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	masked := body
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		masked = r.maskJSON(trimmed)
+	} else if bytes.IndexByte(trimmed, '=') > 0 {
+		if query, err := url.ParseQuery(string(trimmed)); err == nil {
+			masked = []byte(r.maskValues(query).Encode())
+		}
+	}
+
+	if r.MaxBodySize > 0 && len(masked) > r.MaxBodySize {
+		return fmt.Sprintf("%s...(truncated %d bytes)", masked[:r.MaxBodySize], len(masked)-r.MaxBodySize)
+	}
+	return string(masked)
+}
+
+func (r *Redactor) maskValues(values url.Values) url.Values {
+	for key := range values {
+		if r.isField(key) {
+			values[key] = []string{redactedValue}
+		}
+	}
+	return values
+}
+
+func (r *Redactor) maskJSON(body []byte) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return body
+	}
+
+	masked, err := json.Marshal(r.maskValue(data))
+	if err != nil {
+		return body
+	}
+	return masked
+}
+
+func (r *Redactor) maskValue(data interface{}) interface{} {
+	switch value := data.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			if r.isField(k) {
+				value[k] = redactedValue
+				continue
+			}
+			value[k] = r.maskValue(v)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = r.maskValue(item)
+		}
+	}
+	return data
+}
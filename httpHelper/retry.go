@@ -0,0 +1,83 @@
+package httpHelper
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy 重试策略，只重试幂等方法或带 Idempotency-Key 的请求
+type RetryPolicy struct {
+	// MaxAttempts 最大尝试次数（含第一次），小于等于 1 不重试
+	MaxAttempts int
+	// BaseDelay 指数退避的初始间隔
+	BaseDelay time.Duration
+	// MaxDelay 单次退避的上限
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy 3 次尝试，200ms 起指数退避，最长 2s
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   200 * time.Millisecond,
+		MaxDelay:    2 * time.Second,
+	}
+}
+
+// IdempotencyKeyHeader 带该 header 的非幂等请求也允许重试
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+func isIdempotent(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return request.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+// retryable 5xx、429 和网络错误可重试，调用方主动取消不重试
+func retryable(ctx context.Context, res *Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen)
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+}
+
+// backoff 带全抖动的指数退避，429 时优先使用 Retry-After
+func (p *RetryPolicy) backoff(attempt int, res *Response) time.Duration {
+	if res != nil && res.StatusCode == http.StatusTooManyRequests {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			if delay := time.Duration(seconds) * time.Second; p.MaxDelay <= 0 || delay <= p.MaxDelay {
+				return delay
+			}
+		}
+	}
+
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
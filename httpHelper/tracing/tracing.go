@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/kmcqqq/pkg/httpHelper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+const instrumentationName = "github.com/kmcqqq/pkg/httpHelper"
+
+// Tracer 为每次出站请求创建 client span，并通过 W3C traceparent 向下游传播
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	// PathNormalizer span 名称中的路径归一化，为空使用 httpHelper.NormalizePath
+	PathNormalizer func(path string) string
+}
+
+var _ httpHelper.Instrumenter = &Tracer{}
+
+// New 创建 Tracer，provider 为空使用 otel 全局 TracerProvider
+func New(provider trace.TracerProvider) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &Tracer{
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Before 开启 span 并注入 traceparent/tracestate header
+func (t *Tracer) Before(ctx context.Context, request *http.Request) context.Context {
+	normalize := t.PathNormalizer
+	if normalize == nil {
+		normalize = httpHelper.NormalizePath
+	}
+
+	ctx, _ = t.tracer.Start(ctx, fmt.Sprintf("HTTP %s %s", request.Method, normalize(request.URL.Path)),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", request.Method),
+			attribute.String("server.address", request.URL.Hostname()),
+			attribute.String("url.path", request.URL.Path),
+		),
+	)
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	return ctx
+}
+
+// After 记录状态码或错误并结束 span，5xx 标记为错误
+func (t *Tracer) After(ctx context.Context, request *http.Request, res *httpHelper.Response, err error, elapsed time.Duration) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, res.Status)
+	}
+}
@@ -1,6 +1,7 @@
 package huanxin
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/kmcqqq/pkg/config"
@@ -14,6 +15,7 @@ type HxClient struct {
 	clientSecret string
 	appKey       string
 	baseUrl      string
+	httpHelper.ClientHolder
 }
 
 func NewHuanXinClient(cfg *config.HuanXinConfig) (*HxClient, error) {
@@ -25,6 +27,18 @@ func NewHuanXinClient(cfg *config.HuanXinConfig) (*HxClient, error) {
 	}, nil
 }
 
+// SetHttpClient 替换底层 http 客户端
+func (h *HxClient) SetHttpClient(client *httpHelper.Client) {
+	h.HttpClient = client
+}
+
+// WithContext 返回绑定 ctx 的浅拷贝，请求随 ctx 取消
+func (h *HxClient) WithContext(ctx context.Context) *HxClient {
+	client := *h
+	client.ClientHolder = h.ClientHolder.WithContext(ctx)
+	return &client
+}
+
 func (h *HxClient) GetOrgName() string {
 	return strings.Split(h.appKey, "#")[0]
 }
@@ -40,7 +54,8 @@ func (h *HxClient) GetAccessToken() (string, error) {
 		"client_id":     h.clientId,
 		"client_secret": h.clientSecret,
 	}
-	res, err := httpHelper.Post(url, data, nil)
+	ctx, client := h.Client()
+	res, err := client.Post(ctx, url, data, nil)
 	if err != nil {
 		return "", err
 	}
@@ -68,7 +83,8 @@ func (h *HxClient) RegisterUser(userName string, password string, accessToken st
 	headers := map[string]string{
 		"Authorization": "Bearer " + accessToken,
 	}
-	res, err := httpHelper.Post(url, data, headers)
+	ctx, client := h.Client()
+	res, err := client.Post(ctx, url, data, headers)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,50 @@
+package huanxin
+
+import (
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/httpHelper"
+	"github.com/kmcqqq/pkg/httpHelper/recorder"
+	"github.com/kmcqqq/pkg/logger"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(&config.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+func TestRegisterUserReplay(t *testing.T) {
+	rec, err := recorder.New("testdata/register_user.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewHuanXinClient(&config.HuanXinConfig{
+		ClientId:     "client-id",
+		ClientSecret: "client-secret",
+		AppKey:       "org-1#app-1",
+		Url:          "https://a1.easemob.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetHttpClient(httpHelper.NewClient(&httpHelper.ClientConfig{Transport: rec}))
+
+	// golden 文件中 access_token 已脱敏
+	token, err := client.GetAccessToken()
+	if err != nil || token == "" {
+		t.Fatalf("token = %q, err = %v", token, err)
+	}
+
+	uuid, err := client.RegisterUser("u10001", "p@ss", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uuid != "0ffe2d80-ed76-11e8-8d66-279e3e1c214b" {
+		t.Fatalf("uuid = %s", uuid)
+	}
+	if n := rec.Unused(); n != 0 {
+		t.Fatalf("unused = %d", n)
+	}
+}
@@ -1,17 +1,21 @@
 package payermax
 
 import (
+	"context"
 	"crypto/rsa"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/kmcqqq/pkg/config"
 	"github.com/kmcqqq/pkg/httpHelper"
 	"github.com/kmcqqq/pkg/utils"
 	"github.com/mitchellh/mapstructure"
+	"net/http"
 	"time"
 )
 
 type Client struct {
+	httpHelper.ClientHolder
 	AppId           string
 	MerchantNo      string
 	PrivateKey      *rsa.PrivateKey
@@ -19,6 +23,7 @@ type Client struct {
 	ReturnUrl       string
 	NotifyUrl       string
 	PayOutNotifyUrl string
+	RefundNotifyUrl string
 	Url             string
 }
 
@@ -29,6 +34,7 @@ func NewClient(cfg *config.PayConfig) (*Client, error) {
 		ReturnUrl:       cfg.PayerMax.ReturnUrl,
 		NotifyUrl:       cfg.PayerMax.NotifyUrl,
 		PayOutNotifyUrl: cfg.PayerMax.PayOutNotifyUrl,
+		RefundNotifyUrl: cfg.PayerMax.RefundNotifyUrl,
 		Url:             cfg.PayerMax.Url,
 	}
 
@@ -47,6 +53,13 @@ func NewClient(cfg *config.PayConfig) (*Client, error) {
 	return &client, nil
 }
 
+// WithContext 返回绑定 ctx 的浅拷贝，请求随 ctx 取消
+func (c *Client) WithContext(ctx context.Context) *Client {
+	client := *c
+	client.ClientHolder = c.ClientHolder.WithContext(ctx)
+	return &client
+}
+
 const (
 	Order               = "orderAndPay"
 	OrderQuery          = "orderQuery"
@@ -94,7 +107,11 @@ func (c *Client) Do(orderInfo interface{}, payType string) (*Response, error) {
 		"sign": sign,
 	}
 
-	res, err := httpHelper.Post(url, requestData, header)
+	ctx, client := c.Client()
+	res, err := client.Post(ctx, url, requestData, header)
+	if err != nil {
+		return nil, err
+	}
 
 	var response Response
 	err = utils.Json2Struct(res, &response)
@@ -319,6 +336,174 @@ type PayOutNotify struct {
 	} `json:"data"`
 }
 
+type RefundRequest struct {
+	OutRefundNo     string  `json:"outRefundNo"`
+	RefundAmount    float64 `json:"refundAmount"`
+	RefundCurrency  string  `json:"refundCurrency"`
+	OutTradeNo      string  `json:"outTradeNo"`
+	Comments        string  `json:"comments"`
+	RefundNotifyUrl string  `json:"refundNotifyUrl"`
+}
+
+type RefundResponseData struct {
+	OutRefundNo   string `mapstructure:"outRefundNo" json:"outRefundNo"`
+	RefundTradeNo string `mapstructure:"refundTradeNo" json:"refundTradeNo"`
+	Status        string `mapstructure:"status" json:"status"`
+}
+
+// Refund 退款
+func (c *Client) Refund(r *RefundRequest) (*RefundResponseData, error) {
+	if r.RefundNotifyUrl == "" {
+		r.RefundNotifyUrl = c.RefundNotifyUrl
+	}
+	res, err := c.Do(r, Refund)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Code == "APPLY_SUCCESS" {
+		var refund RefundResponseData
+		if err := mapstructure.Decode(res.Data, &refund); err != nil {
+			return nil, errors.New("res.Date format error")
+		}
+
+		return &refund, nil
+	}
+
+	return nil, errors.New(res.Msg)
+}
+
+type RefundQueryRequest struct {
+	OutRefundNo string `json:"outRefundNo"`
+}
+
+type RefundQueryResponseData struct {
+	OutRefundNo    string  `mapstructure:"outRefundNo" json:"outRefundNo"`
+	RefundTradeNo  string  `mapstructure:"refundTradeNo" json:"refundTradeNo"`
+	OutTradeNo     string  `mapstructure:"outTradeNo" json:"outTradeNo"`
+	RefundAmount   float64 `mapstructure:"refundAmount" json:"refundAmount"`
+	RefundCurrency string  `mapstructure:"refundCurrency" json:"refundCurrency"`
+	Status         string  `mapstructure:"status" json:"status"`
+	ResultMsg      string  `mapstructure:"resultMsg" json:"resultMsg"`
+}
+
+// QueryRefund 退款查询
+func (c *Client) QueryRefund(refundId string) (*RefundQueryResponseData, error) {
+	req := RefundQueryRequest{
+		OutRefundNo: refundId,
+	}
+	res, err := c.Do(req, RefundQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Code == "APPLY_SUCCESS" {
+		var refund RefundQueryResponseData
+		if err := mapstructure.Decode(res.Data, &refund); err != nil {
+			return nil, errors.New("res.Date format error")
+		}
+
+		return &refund, nil
+	}
+
+	return nil, errors.New(res.Msg)
+}
+
+type PayOutQuery struct {
+	OutTradeNo string `json:"outTradeNo"`
+}
+
+type PayOutQueryResponseData struct {
+	OutTradeNo string `mapstructure:"outTradeNo" json:"outTradeNo"`
+	TradeNo    string `mapstructure:"tradeNo" json:"tradeNo"`
+	Status     string `mapstructure:"status" json:"status"`
+	Trade      struct {
+		Amount   string `mapstructure:"amount" json:"amount"`
+		Currency string `mapstructure:"currency" json:"currency"`
+	} `mapstructure:"trade" json:"trade"`
+	TransactionUtcTime string `mapstructure:"transactionUtcTime" json:"transactionUtcTime"`
+	PayFinishTime      string `mapstructure:"payFinishTime" json:"payFinishTime"`
+	BounceBackTime     string `mapstructure:"bounceBackTime" json:"bounceBackTime"`
+	Reference          string `mapstructure:"reference" json:"reference"`
+	ResponseCode       string `mapstructure:"responseCode" json:"responseCode"`
+	ResponseMsg        string `mapstructure:"responseMsg" json:"responseMsg"`
+}
+
+// QueryPayout 代付查询
+func (c *Client) QueryPayout(orderId string) (*PayOutQueryResponseData, error) {
+	req := PayOutQuery{
+		OutTradeNo: orderId,
+	}
+	res, err := c.Do(req, OutPayQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Code == "APPLY_SUCCESS" {
+		var pay PayOutQueryResponseData
+		if err := mapstructure.Decode(res.Data, &pay); err != nil {
+			return nil, errors.New("res.Date format error")
+		}
+
+		return &pay, nil
+	}
+
+	return nil, errors.New(res.Msg)
+}
+
+type BalanceQuery struct {
+	Currency string `json:"currency"`
+}
+
+type BalanceQueryResponseData struct {
+	Currency         string `mapstructure:"currency" json:"currency"`
+	TotalBalance     string `mapstructure:"totalBalance" json:"totalBalance"`
+	AvailableBalance string `mapstructure:"availableBalance" json:"availableBalance"`
+	FrozenBalance    string `mapstructure:"frozenBalance" json:"frozenBalance"`
+}
+
+// QueryBalance 查询账户当前余额
+func (c *Client) QueryBalance(currency string) (*BalanceQueryResponseData, error) {
+	req := BalanceQuery{
+		Currency: currency,
+	}
+	res, err := c.Do(req, CurrentBalanceQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Code == "APPLY_SUCCESS" {
+		var balance BalanceQueryResponseData
+		if err := mapstructure.Decode(res.Data, &balance); err != nil {
+			return nil, errors.New("res.Date format error")
+		}
+
+		return &balance, nil
+	}
+
+	return nil, errors.New(res.Msg)
+}
+
+type RefundNotify struct {
+	Code       string `json:"code"`
+	Msg        string `json:"msg"`
+	KeyVersion string `json:"keyVersion"`
+	AppID      string `json:"appId"`
+	MerchantNo string `json:"merchantNo"`
+	NotifyTime string `json:"notifyTime"`
+	NotifyType string `json:"notifyType"`
+	Data       struct {
+		OutRefundNo    string  `json:"outRefundNo"`
+		RefundTradeNo  string  `json:"refundTradeNo"`
+		OutTradeNo     string  `json:"outTradeNo"`
+		RefundAmount   float64 `json:"refundAmount"`
+		RefundCurrency string  `json:"refundCurrency"`
+		Status         string  `json:"status"`
+		CompleteTime   string  `json:"completeTime"`
+		ResultMsg      string  `json:"resultMsg"`
+	} `json:"data"`
+}
+
 func getTimeStr() string {
 	unixMilli := time.Now().UnixMilli()
 	if unixMilli%10 == 0 {
@@ -327,3 +512,70 @@ func getTimeStr() string {
 	t := time.UnixMilli(unixMilli)
 	return t.Format("2006-01-02T15:04:05.999Z07:00")
 }
+
+const (
+	NotifyTypePayment = "PAYMENT"
+	NotifyTypePayout  = "PAYOUT"
+	NotifyTypeRefund  = "REFUND"
+)
+
+var ErrInvalidSign = errors.New("payermax: invalid notify sign")
+
+// Notify 验签后的回调，根据 NotifyType 取对应字段
+type Notify struct {
+	NotifyType string
+	Pay        *PayNotify
+	PayOut     *PayOutNotify
+	Refund     *RefundNotify
+}
+
+// VerifyNotify 校验回调 header 中的 sign 并解析回调内容
+func (c *Client) VerifyNotify(rawBody []byte, signHeader string) (*Notify, error) {
+	if c.PublicKey == nil || signHeader == "" {
+		return nil, ErrInvalidSign
+	}
+	ok, err := utils.VerySignWithRsa(string(rawBody), signHeader, c.PublicKey)
+	if err != nil || !ok {
+		return nil, ErrInvalidSign
+	}
+
+	var head struct {
+		NotifyType string `json:"notifyType"`
+		AppID      string `json:"appId"`
+		MerchantNo string `json:"merchantNo"`
+	}
+	if err := json.Unmarshal(rawBody, &head); err != nil {
+		return nil, err
+	}
+	if head.MerchantNo != "" && head.MerchantNo != c.MerchantNo {
+		return nil, fmt.Errorf("payermax: unexpected merchantNo %s", head.MerchantNo)
+	}
+
+	notify := &Notify{NotifyType: head.NotifyType}
+	switch head.NotifyType {
+	case NotifyTypePayment:
+		notify.Pay = &PayNotify{}
+		err = json.Unmarshal(rawBody, notify.Pay)
+	case NotifyTypePayout:
+		notify.PayOut = &PayOutNotify{}
+		err = json.Unmarshal(rawBody, notify.PayOut)
+	case NotifyTypeRefund:
+		notify.Refund = &RefundNotify{}
+		err = json.Unmarshal(rawBody, notify.Refund)
+	default:
+		return nil, fmt.Errorf("payermax: unsupported notifyType %s", head.NotifyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return notify, nil
+}
+
+// WriteNotifyAck 回写 PayerMax 要求的回调应答，未应答 PayerMax 会重复推送
+func WriteNotifyAck(w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write([]byte(`{"code":"SUCCESS","msg":"Success"}`))
+	return err
+}
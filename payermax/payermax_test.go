@@ -0,0 +1,89 @@
+package payermax
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/httpHelper"
+	"github.com/kmcqqq/pkg/httpHelper/recorder"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/utils"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(&config.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+// signChecker 校验请求 header 中的 sign，golden 文件不保存签名
+type signChecker struct {
+	next      http.RoundTripper
+	publicKey *rsa.PublicKey
+	t         *testing.T
+}
+
+func (s *signChecker) RoundTrip(r *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	ok, err := utils.VerySignWithRsa(string(body), r.Header.Get("sign"), s.publicKey)
+	if err != nil || !ok {
+		s.t.Errorf("%s: invalid sign header: %v", r.URL.Path, err)
+	}
+	return s.next.RoundTrip(r)
+}
+
+func TestRefundReplay(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := recorder.New("testdata/refund.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{
+		AppId:           "app-1",
+		MerchantNo:      "M-1",
+		PrivateKey:      key,
+		Url:             "https://pay-gate.payermax.com/aggregate-pay/api/gateway",
+		RefundNotifyUrl: "https://api.example.com/notify/payermax/refund",
+	}
+	client.HttpClient = httpHelper.NewClient(&httpHelper.ClientConfig{
+		Transport: &signChecker{next: rec, publicKey: &key.PublicKey, t: t},
+	})
+
+	refund, err := client.Refund(&RefundRequest{
+		OutRefundNo:    "R20240101001",
+		RefundAmount:   9.99,
+		RefundCurrency: "USD",
+		OutTradeNo:     "P20240101001",
+		Comments:       "user request",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.OutRefundNo != "R20240101001" || refund.Status != "REFUND_PENDING" {
+		t.Fatalf("unexpected refund %+v", refund)
+	}
+
+	query, err := client.QueryRefund("R20240101001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.Status != "REFUND_SUCCESS" || query.RefundAmount != 9.99 || query.RefundCurrency != "USD" {
+		t.Fatalf("unexpected refund query %+v", query)
+	}
+	if n := rec.Unused(); n != 0 {
+		t.Fatalf("unused = %d", n)
+	}
+}
@@ -0,0 +1,197 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/binance"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/utils"
+	"net/http"
+	"time"
+)
+
+type binanceProvider struct {
+	client *binance.Client
+}
+
+var _ PaymentProvider = &binanceProvider{}
+
+func NewBinanceProvider(cfg *config.PayConfig) (PaymentProvider, error) {
+	client, err := binance.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &binanceProvider{client: client}, nil
+}
+
+func (b *binanceProvider) Channel() Channel {
+	return ChannelBinance
+}
+
+func (b *binanceProvider) CreateOrder(ctx context.Context, req *OrderRequest) (*OrderResult, error) {
+	terminalType := req.Extra["terminalType"]
+	if terminalType == "" {
+		terminalType = "APP"
+	}
+
+	order := &binance.PayOrderRequest{
+		MerchantTradeNo: req.OrderId,
+		OrderAmount:     req.Amount.Value,
+		Currency:        req.Amount.Currency,
+		Description:     req.Subject,
+		GoodsDetails: []binance.GoodsDetails{
+			{
+				GoodsType:        "02",
+				GoodsCategory:    "Z000",
+				GoodsName:        req.Subject,
+				ReferenceGoodsId: req.Extra["productId"],
+			},
+		},
+	}
+	order.Env.TerminalType = terminalType
+
+	res, err := b.client.WithContext(ctx).PayOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderResult{
+		OrderId:  req.OrderId,
+		TradeNo:  res.PrepayId,
+		Status:   StatusPending,
+		Amount:   req.Amount,
+		PayUrl:   res.CheckoutUrl,
+		ExpireAt: time.UnixMilli(res.ExpireTime),
+		Raw:      res,
+	}, nil
+}
+
+func (b *binanceProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	res, err := b.client.WithContext(ctx).PayOrderQuery(&binance.QueryPayOrderRequest{MerchantTradeNo: orderId})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderResult{
+		OrderId: res.MerchantTradeNo,
+		TradeNo: res.PrepayId,
+		Status:  binanceStatus(res.Status),
+		Amount: Amount{
+			Value:    utils.StringToFloat64(res.OrderAmount),
+			Currency: res.Currency,
+		},
+		Raw: res,
+	}, nil
+}
+
+func (b *binanceProvider) VerifyNotify(header http.Header, body []byte) (*Notify, error) {
+	event, err := b.client.ParseWebhook(header, body)
+	if errors.Is(err, binance.ErrInvalidSignature) {
+		return nil, ErrInvalidSign
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch event.BizType {
+	case binance.BizTypeRefund:
+		n := event.Refund
+		return &Notify{
+			Type:    NotifyRefund,
+			OrderId: n.Data.RefundInfo.RefundRequestId,
+			TradeNo: n.Data.RefundInfo.PrepayId,
+			Status:  binanceStatus(n.BizStatus),
+			Amount:  Amount{Value: n.Data.RefundInfo.RefundAmount, Currency: n.Data.Currency},
+			Raw:     n,
+		}, nil
+	case binance.BizTypePayout:
+		n := event.Payout
+		return &Notify{
+			Type:    NotifyPayout,
+			OrderId: n.Data.RequestId,
+			TradeNo: n.BizIdStr,
+			Status:  binanceStatus(n.Data.BatchStatus),
+			Amount:  Amount{Value: n.Data.TotalAmount, Currency: n.Data.Currency},
+			Raw:     n,
+		}, nil
+	default:
+		n := event.Pay
+		return &Notify{
+			Type:    NotifyPay,
+			OrderId: n.Data.MerchantTradeNo,
+			TradeNo: n.BizIdStr,
+			Status:  binanceStatus(n.BizStatus),
+			Amount:  Amount{Value: n.Data.TotalFee, Currency: n.Data.Currency},
+			Raw:     n,
+		}, nil
+	}
+}
+
+func (b *binanceProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	res, err := b.client.WithContext(ctx).Refund(&binance.RefundRequest{
+		RefundRequestId: req.RefundId,
+		PrepayId:        req.TradeNo,
+		RefundAmount:    req.Amount.Value,
+		RefundReason:    req.Reason,
+		WebhookUrl:      req.NotifyUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{
+		RefundId: res.RefundRequestId,
+		Status:   StatusRefunding,
+		Raw:      res,
+	}, nil
+}
+
+func (b *binanceProvider) Payout(ctx context.Context, req *PayoutRequest) (*PayoutResult, error) {
+	receiveType := req.PaymentMethod
+	if receiveType == "" {
+		receiveType = "BINANCE_ID"
+	}
+
+	res, err := b.client.WithContext(ctx).Transfer(&binance.TransferRequest{
+		RequestId: req.OrderId,
+		BatchName: req.OrderId,
+		Currency:  req.Amount.Currency,
+		TransferDetailList: []binance.TransferDetail{
+			{
+				MerchantSendId: req.OrderId,
+				ReceiveType:    receiveType,
+				Receiver:       req.AccountNo,
+				TransferAmount: req.Amount.Value,
+				TransferMethod: "FUNDING_WALLET",
+				Remark:         req.Remark,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PayoutResult{
+		OrderId: res.RequestId,
+		Status:  binanceStatus(res.Status),
+		Raw:     res,
+	}, nil
+}
+
+func binanceStatus(status string) OrderStatus {
+	switch status {
+	case "PAID", "PAY_SUCCESS", "SUCCESS":
+		return StatusSuccess
+	case "INITIAL", "PENDING", "ACCEPTED", "PROCESSING":
+		return StatusPending
+	case "ERROR", "FAILED", "REFUND_REJECTED":
+		return StatusFailed
+	case "CANCELED", "EXPIRED", "PAY_CLOSED":
+		return StatusClosed
+	case "REFUNDING":
+		return StatusRefunding
+	case "REFUNDED", "FULL_REFUNDED", "REFUND_SUCCESS":
+		return StatusRefunded
+	default:
+		return StatusUnknown
+	}
+}
@@ -0,0 +1,91 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/coda"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/utils"
+	"net/http"
+)
+
+type codaProvider struct {
+	client *coda.Client
+}
+
+var _ PaymentProvider = &codaProvider{}
+
+// NewCodaProviders 按账户构建 coda 渠道
+func NewCodaProviders(cfg *config.PayConfig) (map[string]PaymentProvider, error) {
+	clients, err := coda.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := make(map[string]PaymentProvider, len(clients))
+	for account, client := range clients {
+		providers[account] = &codaProvider{client: client}
+	}
+	return providers, nil
+}
+
+func (c *codaProvider) Channel() Channel {
+	return ChannelCoda
+}
+
+func (c *codaProvider) CreateOrder(ctx context.Context, req *OrderRequest) (*OrderResult, error) {
+	res, err := c.client.WithContext(ctx).PayOrder(coda.PayOrder{
+		OrderId: req.OrderId,
+		Coin:    req.Quantity,
+		Amount:  req.Amount.Value,
+		UserIdx: utils.StringToInt64(req.UserId),
+		PayType: utils.StringToInt(req.PaymentMethod),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderResult{
+		OrderId: req.OrderId,
+		TradeNo: utils.Int64ToString(res.InitResult.TxnId),
+		Status:  StatusPending,
+		Amount:  req.Amount,
+		PayUrl:  c.client.BuildRedirectURL(res.InitResult.TxnId, req.Extra["browserType"]),
+		Raw:     res,
+	}, nil
+}
+
+func (c *codaProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *codaProvider) VerifyNotify(header http.Header, body []byte) (*Notify, error) {
+	n, err := c.client.VerifyNotify(body)
+	if errors.Is(err, coda.ErrInvalidSign) {
+		return nil, ErrInvalidSign
+	} else if err != nil {
+		return nil, err
+	}
+
+	status := StatusFailed
+	if n.ResultCode == "0" {
+		status = StatusSuccess
+	}
+
+	return &Notify{
+		Type:    NotifyPay,
+		OrderId: n.OrderId,
+		TradeNo: n.TxnId,
+		Status:  status,
+		Amount:  Amount{Value: utils.StringToFloat64(n.TotalPrice)},
+		Raw:     n,
+	}, nil
+}
+
+func (c *codaProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	return nil, ErrNotSupported
+}
+
+func (c *codaProvider) Payout(ctx context.Context, req *PayoutRequest) (*PayoutResult, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,186 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/payermax"
+	"github.com/kmcqqq/pkg/utils"
+	"net/http"
+)
+
+type payerMaxProvider struct {
+	client *payermax.Client
+}
+
+var _ PaymentProvider = &payerMaxProvider{}
+
+func NewPayerMaxProvider(cfg *config.PayConfig) (PaymentProvider, error) {
+	client, err := payermax.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &payerMaxProvider{client: client}, nil
+}
+
+func (p *payerMaxProvider) Channel() Channel {
+	return ChannelPayerMax
+}
+
+func (p *payerMaxProvider) CreateOrder(ctx context.Context, req *OrderRequest) (*OrderResult, error) {
+	order := &payermax.PayOrder{
+		OutTradeNo:  req.OrderId,
+		Subject:     req.Subject,
+		TotalAmount: req.Amount.Value,
+		Currency:    req.Amount.Currency,
+		Country:     req.Country,
+		UserID:      req.UserId,
+		Reference:   req.Extra["reference"],
+	}
+	order.PaymentDetail.PaymentMethodType = req.PaymentMethod
+	order.PaymentDetail.TargetOrg = req.TargetOrg
+
+	res, err := p.client.WithContext(ctx).PayOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderResult{
+		OrderId: res.OutTradeNo,
+		TradeNo: res.TradeToken,
+		Status:  payerMaxStatus(res.Status),
+		Amount:  req.Amount,
+		PayUrl:  res.RedirectUrl,
+		Raw:     res,
+	}, nil
+}
+
+func (p *payerMaxProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	res, err := p.client.WithContext(ctx).PayQuery(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrderResult{
+		OrderId: res.OutTradeNo,
+		TradeNo: res.TradeNo,
+		Status:  payerMaxStatus(res.Status),
+		Amount: Amount{
+			Value:    utils.StringToFloat64(res.Trade.Amount),
+			Currency: res.Trade.Currency,
+		},
+		Raw: res,
+	}, nil
+}
+
+func (p *payerMaxProvider) VerifyNotify(header http.Header, body []byte) (*Notify, error) {
+	n, err := p.client.VerifyNotify(body, header.Get("sign"))
+	if errors.Is(err, payermax.ErrInvalidSign) {
+		return nil, ErrInvalidSign
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch n.NotifyType {
+	case payermax.NotifyTypePayout:
+		return &Notify{
+			Type:    NotifyPayout,
+			OrderId: n.PayOut.Data.OutTradeNo,
+			TradeNo: n.PayOut.Data.TradeNo,
+			Status:  payerMaxStatus(n.PayOut.Data.Status),
+			Amount: Amount{
+				Value:    utils.StringToFloat64(n.PayOut.Data.Trade.Amount),
+				Currency: n.PayOut.Data.Trade.Currency,
+			},
+			Raw: n.PayOut,
+		}, nil
+	case payermax.NotifyTypeRefund:
+		return &Notify{
+			Type:    NotifyRefund,
+			OrderId: n.Refund.Data.OutRefundNo,
+			TradeNo: n.Refund.Data.RefundTradeNo,
+			Status:  payerMaxStatus(n.Refund.Data.Status),
+			Amount:  Amount{Value: n.Refund.Data.RefundAmount, Currency: n.Refund.Data.RefundCurrency},
+			Raw:     n.Refund,
+		}, nil
+	default:
+		return &Notify{
+			Type:    NotifyPay,
+			OrderId: n.Pay.Data.OutTradeNo,
+			TradeNo: n.Pay.Data.TradeToken,
+			Status:  payerMaxStatus(n.Pay.Data.Status),
+			Amount:  Amount{Value: n.Pay.Data.TotalAmount, Currency: n.Pay.Data.Currency},
+			Raw:     n.Pay,
+		}, nil
+	}
+}
+
+func (p *payerMaxProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	res, err := p.client.WithContext(ctx).Refund(&payermax.RefundRequest{
+		OutRefundNo:     req.RefundId,
+		RefundAmount:    req.Amount.Value,
+		RefundCurrency:  req.Amount.Currency,
+		OutTradeNo:      req.OrderId,
+		Comments:        req.Reason,
+		RefundNotifyUrl: req.NotifyUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &RefundResult{
+		RefundId:      res.OutRefundNo,
+		RefundTradeNo: res.RefundTradeNo,
+		Status:        payerMaxStatus(res.Status),
+		Raw:           res,
+	}, nil
+}
+
+func (p *payerMaxProvider) Payout(ctx context.Context, req *PayoutRequest) (*PayoutResult, error) {
+	remit := &payermax.RemitRequest{
+		OutTradeNo: req.OrderId,
+		Country:    req.Country,
+		Remark:     req.Remark,
+	}
+	remit.Trade.Amount = utils.Float64ToStringByMoney(req.Amount.Value)
+	remit.Trade.Currency = req.Amount.Currency
+	remit.PayeeInfo.PaymentMethodType = req.PaymentMethod
+	remit.PayeeInfo.TargetOrg = req.TargetOrg
+	remit.PayeeInfo.AccountInfo.AccountNo = req.AccountNo
+	remit.PayeeInfo.Name.FullName = req.AccountName
+	remit.PayeeInfo.PayeePhone = req.Phone
+	remit.PayeeInfo.BankInfo.BankCode = req.BankCode
+
+	res, err := p.client.WithContext(ctx).PayOut(remit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PayoutResult{
+		OrderId: res.OutTradeNo,
+		TradeNo: res.TradeNo,
+		Status:  payerMaxStatus(res.Status),
+		Raw:     res,
+	}, nil
+}
+
+func payerMaxStatus(status string) OrderStatus {
+	switch status {
+	case "SUCCESS":
+		return StatusSuccess
+	case "PENDING", "PAYING":
+		return StatusPending
+	case "FAILED", "BOUNCEBACK":
+		return StatusFailed
+	case "CLOSED":
+		return StatusClosed
+	case "REFUNDING", "REFUND_PENDING":
+		return StatusRefunding
+	case "REFUNDED", "REFUND_SUCCESS":
+		return StatusRefunded
+	case "REFUND_FAILED":
+		return StatusFailed
+	default:
+		return StatusUnknown
+	}
+}
@@ -0,0 +1,238 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Channel 支付渠道
+type Channel string
+
+const (
+	ChannelPayerMax Channel = "payermax"
+	ChannelXendit   Channel = "xendit"
+	ChannelCoda     Channel = "coda"
+	ChannelBinance  Channel = "binance"
+)
+
+// OrderStatus 统一订单状态
+type OrderStatus int
+
+const (
+	StatusUnknown OrderStatus = iota
+	StatusPending
+	StatusSuccess
+	StatusFailed
+	StatusClosed
+	StatusRefunding
+	StatusRefunded
+)
+
+func (s OrderStatus) String() string {
+	switch s {
+	case StatusPending:
+		return "PENDING"
+	case StatusSuccess:
+		return "SUCCESS"
+	case StatusFailed:
+		return "FAILED"
+	case StatusClosed:
+		return "CLOSED"
+	case StatusRefunding:
+		return "REFUNDING"
+	case StatusRefunded:
+		return "REFUNDED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// IsFinal 是否为终态
+func (s OrderStatus) IsFinal() bool {
+	return s == StatusSuccess || s == StatusFailed || s == StatusClosed || s == StatusRefunded
+}
+
+// NotifyType 回调类型
+type NotifyType int
+
+const (
+	NotifyPay NotifyType = iota + 1
+	NotifyRefund
+	NotifyPayout
+)
+
+// Amount 统一金额，Value 为主币单位
+type Amount struct {
+	Value    float64 `json:"value"`
+	Currency string  `json:"currency"`
+}
+
+var (
+	ErrNotSupported    = errors.New("payment: operation not supported by channel")
+	ErrInvalidSign     = errors.New("payment: invalid notify signature")
+	ErrUnknownProvider = errors.New("payment: unknown provider")
+)
+
+// PaymentProvider 支付渠道统一接口，新增渠道只需实现该接口
+type PaymentProvider interface {
+	Channel() Channel
+	CreateOrder(ctx context.Context, req *OrderRequest) (*OrderResult, error)
+	QueryOrder(ctx context.Context, orderId string) (*OrderResult, error)
+	VerifyNotify(header http.Header, body []byte) (*Notify, error)
+	Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error)
+	Payout(ctx context.Context, req *PayoutRequest) (*PayoutResult, error)
+}
+
+// OrderRequest 下单参数
+type OrderRequest struct {
+	OrderId       string
+	Amount        Amount
+	Subject       string
+	UserId        string
+	Country       string
+	PaymentMethod string
+	TargetOrg     string
+	Quantity      int
+	// Extra 渠道特有参数
+	Extra map[string]string
+}
+
+// OrderResult 下单/查单结果
+type OrderResult struct {
+	OrderId  string
+	TradeNo  string
+	Status   OrderStatus
+	Amount   Amount
+	PayUrl   string
+	ExpireAt time.Time
+	Raw      interface{}
+}
+
+// Notify 渠道回调
+type Notify struct {
+	Type    NotifyType
+	OrderId string
+	TradeNo string
+	Status  OrderStatus
+	Amount  Amount
+	Raw     interface{}
+}
+
+// RefundRequest 退款参数
+type RefundRequest struct {
+	OrderId   string
+	RefundId  string
+	TradeNo   string
+	Amount    Amount
+	Reason    string
+	NotifyUrl string
+}
+
+// RefundResult 退款结果
+type RefundResult struct {
+	RefundId      string
+	RefundTradeNo string
+	Status        OrderStatus
+	Raw           interface{}
+}
+
+// PayoutRequest 代付参数
+type PayoutRequest struct {
+	OrderId       string
+	Amount        Amount
+	Country       string
+	PaymentMethod string
+	TargetOrg     string
+	AccountNo     string
+	AccountName   string
+	Phone         string
+	BankCode      string
+	Remark        string
+}
+
+// PayoutResult 代付结果
+type PayoutResult struct {
+	OrderId string
+	TradeNo string
+	Status  OrderStatus
+	Raw     interface{}
+}
+
+// Providers 渠道注册表，key 为 channel 加账户名
+type Providers struct {
+	mu        sync.RWMutex
+	providers map[string]PaymentProvider
+}
+
+func NewProviders() *Providers {
+	return &Providers{providers: make(map[string]PaymentProvider)}
+}
+
+// NewProvidersFromConfig 根据支付配置构建所有渠道
+func NewProvidersFromConfig(cfg *config.PayConfig) (*Providers, error) {
+	p := NewProviders()
+
+	if cfg.PayerMax.AppID != "" {
+		provider, err := NewPayerMaxProvider(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("init payermax provider: %w", err)
+		}
+		p.Register("", provider)
+	}
+
+	xenditProviders, err := NewXenditProviders(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init xendit provider: %w", err)
+	}
+	for account, provider := range xenditProviders {
+		p.Register(account, provider)
+	}
+
+	codaProviders, err := NewCodaProviders(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init coda provider: %w", err)
+	}
+	for account, provider := range codaProviders {
+		p.Register(account, provider)
+	}
+
+	if cfg.Binance.ApiKey != "" {
+		provider, err := NewBinanceProvider(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("init binance provider: %w", err)
+		}
+		p.Register("", provider)
+	}
+
+	return p, nil
+}
+
+// Register 注册渠道，account 为空表示单账户渠道
+func (p *Providers) Register(account string, provider PaymentProvider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.providers[providerKey(provider.Channel(), account)] = provider
+}
+
+// Get 获取渠道
+func (p *Providers) Get(channel Channel, account string) (PaymentProvider, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	provider, ok := p.providers[providerKey(channel, account)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, providerKey(channel, account))
+	}
+	return provider, nil
+}
+
+func providerKey(channel Channel, account string) string {
+	if account == "" {
+		return string(channel)
+	}
+	return fmt.Sprintf("%s:%s", channel, account)
+}
@@ -0,0 +1,154 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/utils"
+	"github.com/kmcqqq/pkg/xendit"
+	"net/http"
+)
+
+type xenditProvider struct {
+	client *xendit.Client
+}
+
+var _ PaymentProvider = &xenditProvider{}
+
+// NewXenditProviders 按账户构建 xendit 渠道
+func NewXenditProviders(cfg *config.PayConfig) (map[string]PaymentProvider, error) {
+	clients, err := xendit.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := make(map[string]PaymentProvider, len(clients))
+	for account, client := range clients {
+		providers[account] = &xenditProvider{client: client}
+	}
+	return providers, nil
+}
+
+func (x *xenditProvider) Channel() Channel {
+	return ChannelXendit
+}
+
+func (x *xenditProvider) CreateOrder(ctx context.Context, req *OrderRequest) (*OrderResult, error) {
+	invoice := xendit.InvoiceRequest{
+		ExternalId:      req.OrderId,
+		Amount:          req.Amount.Value,
+		Currency:        req.Amount.Currency,
+		Description:     req.Subject,
+		InvoiceDuration: uint(utils.StringToInt(req.Extra["invoiceDuration"])),
+	}
+	if req.PaymentMethod != "" {
+		invoice.PaymentMethods = []string{req.PaymentMethod}
+	}
+
+	res, err := x.client.WithContext(ctx).CreateInvoice(invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	return xenditInvoiceResult(res), nil
+}
+
+func (x *xenditProvider) QueryOrder(ctx context.Context, orderId string) (*OrderResult, error) {
+	res, err := x.client.WithContext(ctx).GetInvoice(orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	return xenditInvoiceResult(res), nil
+}
+
+func (x *xenditProvider) VerifyNotify(header http.Header, body []byte) (*Notify, error) {
+	callback, err := x.client.VerifyCallbackBody(header.Get("x-callback-token"), body)
+	if errors.Is(err, xendit.ErrInvalidToken) {
+		return nil, ErrInvalidSign
+	} else if err != nil {
+		return nil, err
+	}
+
+	if callback.PayOut != nil {
+		n := callback.PayOut
+		return &Notify{
+			Type:    NotifyPayout,
+			OrderId: n.Data.ReferenceID,
+			TradeNo: n.Data.ID,
+			Status:  xenditStatus(n.Data.Status),
+			Amount:  Amount{Value: n.Data.Amount, Currency: n.Data.Currency},
+			Raw:     n,
+		}, nil
+	}
+
+	n := callback.Invoice
+	return &Notify{
+		Type:    NotifyPay,
+		OrderId: n.ExternalID,
+		TradeNo: n.ID,
+		Status:  xenditStatus(n.Status),
+		Amount:  Amount{Value: n.PaidAmount, Currency: n.Currency},
+		Raw:     n,
+	}, nil
+}
+
+func (x *xenditProvider) Refund(ctx context.Context, req *RefundRequest) (*RefundResult, error) {
+	return nil, ErrNotSupported
+}
+
+func (x *xenditProvider) Payout(ctx context.Context, req *PayoutRequest) (*PayoutResult, error) {
+	payout := xendit.PayoutRequest{
+		ReferenceID: req.OrderId,
+		ChannelCode: req.PaymentMethod,
+		ChannelProperties: xendit.ChannelProperties{
+			AccountHolderName: req.AccountName,
+			AccountNumber:     req.AccountNo,
+		},
+		Amount:      req.Amount.Value,
+		Description: req.Remark,
+		Currency:    req.Amount.Currency,
+	}
+
+	res, err := x.client.WithContext(ctx).PayOut(payout)
+	if err != nil {
+		return nil, err
+	}
+	if res.ErrorCode != "" {
+		return nil, errors.New(res.Message)
+	}
+
+	return &PayoutResult{
+		OrderId: res.ReferenceID,
+		TradeNo: res.ID,
+		Status:  xenditStatus(res.Status),
+		Raw:     res,
+	}, nil
+}
+
+func xenditInvoiceResult(res *xendit.InvoiceResponse) *OrderResult {
+	return &OrderResult{
+		OrderId:  res.ExternalID,
+		TradeNo:  res.ID,
+		Status:   xenditStatus(res.Status),
+		Amount:   Amount{Value: res.Amount, Currency: res.Currency},
+		PayUrl:   res.InvoiceURL,
+		ExpireAt: res.ExpiryDate,
+		Raw:      res,
+	}
+}
+
+func xenditStatus(status string) OrderStatus {
+	switch status {
+	case "PAID", "SETTLED", "SUCCEEDED":
+		return StatusSuccess
+	case "PENDING", "ACCEPTED", "REQUESTED":
+		return StatusPending
+	case "FAILED", "REVERSED":
+		return StatusFailed
+	case "EXPIRED", "CANCELLED":
+		return StatusClosed
+	default:
+		return StatusUnknown
+	}
+}
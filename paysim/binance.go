@@ -0,0 +1,319 @@
+package paysim
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/kmcqqq/pkg/binance"
+	"github.com/kmcqqq/pkg/payment"
+	"github.com/kmcqqq/pkg/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handleBinance 校验 HMAC 签名后按路径分发，路径与 binance.Client 一致
+func (s *Server) handleBinance(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, binanceFail("400000", err.Error()))
+		return
+	}
+
+	if r.Header.Get("BinancePay-Certificate-SN") != BinanceApiKey || !binanceVerify(r.Header, body) {
+		writeJSON(w, http.StatusOK, binanceFail("400002", "Signature for this request is not valid."))
+		return
+	}
+
+	var data interface{}
+	switch r.URL.Path {
+	case "/binancepay/openapi/certificates":
+		data = []map[string]string{{"certSerial": BinanceCertSerial, "certPublic": s.platformPublicKey()}}
+	case "/binancepay/openapi/v3/order":
+		data, err = s.binanceCreateOrder(body)
+	case "/binancepay/openapi/v2/order/query":
+		data, err = s.binanceQueryOrder(body)
+	case "/binancepay/openapi/order/close":
+		data, err = s.binanceCloseOrder(body)
+	case "/binancepay/openapi/order/refund":
+		data, err = s.binanceRefund(body)
+	case "/binancepay/openapi/order/refund/query":
+		data, err = s.binanceQueryRefund(body)
+	case "/binancepay/openapi/payout/transfer":
+		data, err = s.binanceTransfer(body)
+	case "/binancepay/openapi/payout/query":
+		data, err = s.binanceQueryTransfer(body)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusOK, binanceFail("400202", err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, binance.Response{Status: "SUCCESS", Code: "000000", Data: data})
+}
+
+func binanceFail(code, message string) binance.Response {
+	return binance.Response{Status: "FAIL", Code: code, ErrorMessage: message}
+}
+
+func binanceVerify(header http.Header, body []byte) bool {
+	signString := header.Get("BinancePay-Timestamp") + "\n" + header.Get("BinancePay-Nonce") + "\n" + string(body) + "\n"
+	h := hmac.New(sha512.New, []byte(BinanceSecretKey))
+	h.Write([]byte(signString))
+	expected := strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+	return hmac.Equal([]byte(expected), []byte(header.Get("BinancePay-Signature")))
+}
+
+func (s *Server) binanceCreateOrder(body []byte) (interface{}, error) {
+	var req binance.PayOrderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	order := s.create(&Order{
+		Type:     payment.NotifyPay,
+		Channel:  payment.ChannelBinance,
+		OrderId:  req.MerchantTradeNo,
+		TradeNo:  utils.Int64ToString(s.nextTxnId()),
+		Amount:   req.OrderAmount,
+		Currency: req.Currency,
+		Status:   "INITIAL",
+	})
+
+	checkoutUrl := s.URL + binancePrefix + "/checkout/" + order.TradeNo
+	return binance.PayOrderResponse{
+		PrepayId:     order.TradeNo,
+		TerminalType: req.Env.TerminalType,
+		ExpireTime:   order.CreatedAt.Add(time.Hour).UnixMilli(),
+		QrcodeLink:   checkoutUrl + "/qrcode",
+		QrContent:    checkoutUrl,
+		CheckoutUrl:  checkoutUrl,
+		Deeplink:     checkoutUrl,
+		UniversalUrl: checkoutUrl,
+	}, nil
+}
+
+// binanceFindOrder 按 merchantTradeNo 或 prepayId 查找支付订单
+func (s *Server) binanceFindOrder(merchantTradeNo, prepayId string) (*Order, bool) {
+	if merchantTradeNo != "" {
+		return s.find(payment.NotifyPay, payment.ChannelBinance, merchantTradeNo)
+	}
+	return s.findBy(func(order *Order) bool {
+		return order.Type == payment.NotifyPay && order.Channel == payment.ChannelBinance && order.TradeNo == prepayId
+	})
+}
+
+func (s *Server) binanceQueryOrder(body []byte) (interface{}, error) {
+	var req binance.QueryPayOrderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	order, ok := s.binanceFindOrder(req.MerchantTradeNo, req.PrepayId)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	return binance.QueryOrderResult{
+		PrepayId:        order.TradeNo,
+		TransactionId:   order.TradeNo,
+		MerchantTradeNo: order.OrderId,
+		Status:          order.Status,
+		Currency:        order.Currency,
+		OrderAmount:     utils.Float64ToString(order.Amount),
+	}, nil
+}
+
+func (s *Server) binanceCloseOrder(body []byte) (interface{}, error) {
+	var req binance.CloseOrderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	order, ok := s.binanceFindOrder(req.MerchantTradeNo, req.PrepayId)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	s.update(payment.NotifyPay, payment.ChannelBinance, order.OrderId, "CANCELED")
+	return true, nil
+}
+
+// binanceRefund 退款直接成功并推送 PAY_REFUND webhook
+func (s *Server) binanceRefund(body []byte) (interface{}, error) {
+	var req binance.RefundRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	paid, ok := s.binanceFindOrder("", req.PrepayId)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	order := s.create(&Order{
+		Type:      payment.NotifyRefund,
+		Channel:   payment.ChannelBinance,
+		OrderId:   req.RefundRequestId,
+		Amount:    req.RefundAmount,
+		Currency:  paid.Currency,
+		Status:    "REFUNDED",
+		NotifyUrl: req.WebhookUrl,
+		RelatedId: paid.OrderId,
+	})
+	s.update(payment.NotifyPay, payment.ChannelBinance, paid.OrderId, "REFUNDED")
+	s.notifyAsync(order)
+
+	result := binanceRefundResult(order, paid)
+	result.RefundStatus = "REFUNDING"
+	return result, nil
+}
+
+func (s *Server) binanceQueryRefund(body []byte) (interface{}, error) {
+	var req binance.QueryRefundRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	order, ok := s.find(payment.NotifyRefund, payment.ChannelBinance, req.RefundRequestId)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	paid, _ := s.find(payment.NotifyPay, payment.ChannelBinance, order.RelatedId)
+	return binanceRefundResult(order, paid), nil
+}
+
+func binanceRefundResult(order, paid *Order) binance.RefundResult {
+	result := binance.RefundResult{
+		RefundRequestId:  order.OrderId,
+		RefundAmount:     order.Amount,
+		RefundedAmount:   order.Amount,
+		DuplicateRequest: "N",
+		RefundStatus:     order.Status,
+	}
+	if paid != nil {
+		result.PrepayId = paid.TradeNo
+		result.OrderAmount = paid.Amount
+	}
+	return result
+}
+
+func (s *Server) binanceTransfer(body []byte) (interface{}, error) {
+	var req binance.TransferRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	var receiver string
+	if len(req.TransferDetailList) > 0 {
+		receiver = req.TransferDetailList[0].Receiver
+	}
+
+	order := s.create(&Order{
+		Type:     payment.NotifyPayout,
+		Channel:  payment.ChannelBinance,
+		OrderId:  req.RequestId,
+		Amount:   req.TotalAmount,
+		Currency: req.Currency,
+		Status:   "ACCEPTED",
+		UserId:   receiver,
+	})
+
+	return binance.TransferResult{RequestId: order.OrderId, Status: order.Status}, nil
+}
+
+func (s *Server) binanceQueryTransfer(body []byte) (interface{}, error) {
+	var req binance.QueryTransferRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+
+	order, ok := s.find(payment.NotifyPayout, payment.ChannelBinance, req.RequestId)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	return binance.QueryTransferResult{
+		RequestId:   order.OrderId,
+		BatchStatus: order.Status,
+		Currency:    order.Currency,
+		TotalAmount: order.Amount,
+		TotalNumber: 1,
+	}, nil
+}
+
+// notifyBinance 推送平台私钥签名的 webhook，data 与真实推送一致为 json 字符串
+func (s *Server) notifyBinance(order *Order) error {
+	var bizType, bizStatus string
+	var data interface{}
+	switch order.Type {
+	case payment.NotifyRefund:
+		var n binance.RefundNotify
+		n.Data.MerchantTradeNo = order.RelatedId
+		n.Data.Currency = order.Currency
+		n.Data.TransactTime = time.Now().UnixMilli()
+		n.Data.RefundInfo.RefundRequestId = order.OrderId
+		n.Data.RefundInfo.RefundAmount = order.Amount
+		n.Data.RefundInfo.RefundedAmount = order.Amount
+		if paid, ok := s.find(payment.NotifyPay, payment.ChannelBinance, order.RelatedId); ok {
+			n.Data.RefundInfo.PrepayId = paid.TradeNo
+			n.Data.RefundInfo.OrderAmount = paid.Amount
+			n.Data.TotalFee = paid.Amount
+		}
+		bizType, bizStatus, data = binance.BizTypeRefund, "REFUND_SUCCESS", n.Data
+	case payment.NotifyPayout:
+		var n binance.PayoutNotify
+		n.Data.RequestId = order.OrderId
+		n.Data.BatchStatus = order.Status
+		n.Data.Currency = order.Currency
+		n.Data.TotalAmount = order.Amount
+		n.Data.TotalNumber = 1
+		bizType, bizStatus, data = binance.BizTypePayout, order.Status, n.Data
+	default:
+		var n binance.PayNotify
+		n.Data.MerchantTradeNo = order.OrderId
+		n.Data.TotalFee = order.Amount
+		n.Data.Currency = order.Currency
+		n.Data.TransactTime = time.Now().UnixMilli()
+		n.Data.TradeType = "APP"
+		bizStatus = "PAY_SUCCESS"
+		if order.Status != "PAID" {
+			bizStatus = "PAY_CLOSED"
+		}
+		bizType, data = binance.BizTypePay, n.Data
+	}
+
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	bizId := s.nextTxnId()
+	body, err := json.Marshal(map[string]interface{}{
+		"bizType":   bizType,
+		"bizId":     bizId,
+		"bizIdStr":  utils.Int64ToString(bizId),
+		"bizStatus": bizStatus,
+		"data":      string(dataBytes),
+	})
+	if err != nil {
+		return err
+	}
+
+	timestamp := utils.Int64ToString(time.Now().UnixMilli())
+	nonce := utils.RandStringRunes(32)
+	signature, err := utils.SignRsa(timestamp+"\n"+nonce+"\n"+string(body)+"\n", s.platformKey)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("BinancePay-Certificate-SN", BinanceCertSerial)
+	header.Set("BinancePay-Timestamp", timestamp)
+	header.Set("BinancePay-Nonce", nonce)
+	header.Set("BinancePay-Signature", signature)
+	return s.deliver(payment.ChannelBinance, order.NotifyUrl, body, header)
+}
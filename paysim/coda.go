@@ -0,0 +1,129 @@
+package paysim
+
+import (
+	"encoding/json"
+	"github.com/kmcqqq/pkg/coda"
+	"github.com/kmcqqq/pkg/payment"
+	"github.com/kmcqqq/pkg/utils"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// handleCoda 按路径分发，路径与 coda.Client 一致，apiKey 在请求体中校验
+func (s *Server) handleCoda(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/init/":
+		s.codaInit(w, r)
+	case "/inquiryPaymentResult/":
+		s.codaInquiry(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) codaInit(w http.ResponseWriter, r *http.Request) {
+	var req coda.RequestParam
+	var res coda.PayOrderResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		res.InitResult.ResultCode = 1
+		res.InitResult.ResultDesc = err.Error()
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	if req.InitRequest.APIKey != CodaApiKey {
+		res.InitResult.ResultCode = 1
+		res.InitResult.ResultDesc = "Invalid API key"
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	var amount float64
+	for _, item := range req.InitRequest.Items {
+		amount += item.Price
+	}
+	var userId string
+	for _, entry := range req.InitRequest.Profile.Entry {
+		if entry.Key == "user_id" {
+			userId = entry.Value
+		}
+	}
+
+	txnId := s.nextTxnId()
+	s.create(&Order{
+		Type:     payment.NotifyPay,
+		Channel:  payment.ChannelCoda,
+		OrderId:  req.InitRequest.OrderID,
+		TradeNo:  utils.Int64ToString(txnId),
+		Amount:   amount,
+		Currency: utils.IntToString(req.InitRequest.Currency),
+		Status:   "PENDING",
+		UserId:   userId,
+	})
+
+	res.InitResult.ResultDesc = "Success"
+	res.InitResult.TxnId = txnId
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) codaInquiry(w http.ResponseWriter, r *http.Request) {
+	var req coda.InquiryRequest
+	var res coda.InquiryResponse
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.InquiryPaymentRequest.APIKey != CodaApiKey {
+		res.PaymentResult.ResultCode = 1
+		res.PaymentResult.ResultDesc = "Invalid request"
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	txnId := utils.Int64ToString(req.InquiryPaymentRequest.TxnId)
+	order, ok := s.findBy(func(order *Order) bool {
+		return order.Channel == payment.ChannelCoda && order.TradeNo == txnId
+	})
+	if !ok {
+		res.PaymentResult.ResultCode = 1
+		res.PaymentResult.ResultDesc = "Transaction not found"
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+
+	res.PaymentResult.ResultCode = codaResultCode(order.Status)
+	res.PaymentResult.ResultDesc = order.Status
+	res.PaymentResult.TxnId = req.InquiryPaymentRequest.TxnId
+	res.PaymentResult.OrderId = order.OrderId
+	res.PaymentResult.TotalPrice = order.Amount
+	writeJSON(w, http.StatusOK, res)
+}
+
+// codaResultCode 0 成功，其他为未完成或失败
+func codaResultCode(status string) int {
+	switch status {
+	case "SUCCESS":
+		return 0
+	case "PENDING":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (s *Server) nextTxnId() int64 {
+	return time.Now().Unix()*1000000 + atomic.AddInt64(&s.seq, 1)%1000000
+}
+
+// notifyCoda 推送 form 格式的回调，Checksum 为 md5(TxnId+ApiKey+OrderId+ResultCode)
+func (s *Server) notifyCoda(order *Order) error {
+	resultCode := utils.IntToString(codaResultCode(order.Status))
+
+	form := url.Values{}
+	form.Set("OrderId", order.OrderId)
+	form.Set("TxnId", order.TradeNo)
+	form.Set("ResultCode", resultCode)
+	form.Set("TotalPrice", utils.Float64ToString(order.Amount))
+	form.Set("Checksum", utils.MD5(order.TradeNo+CodaApiKey+order.OrderId+resultCode))
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.deliver(payment.ChannelCoda, order.NotifyUrl, []byte(form.Encode()), header)
+}
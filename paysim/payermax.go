@@ -0,0 +1,257 @@
+package paysim
+
+import (
+	"encoding/json"
+	"github.com/kmcqqq/pkg/payermax"
+	"github.com/kmcqqq/pkg/payment"
+	"github.com/kmcqqq/pkg/utils"
+	"net/http"
+	"time"
+)
+
+type payerMaxResponse struct {
+	Code string      `json:"code"`
+	Msg  string      `json:"msg"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// handlePayerMax 校验商户签名后按 payType 分发，路径与 payermax.Client.Do 一致
+func (s *Server) handlePayerMax(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, payerMaxResponse{Code: "PARAMS_INVALID", Msg: err.Error()})
+		return
+	}
+
+	ok, err := utils.VerySignWithRsa(string(body), r.Header.Get("sign"), &s.payerMaxMerchantKey.PublicKey)
+	if err != nil || !ok {
+		writeJSON(w, http.StatusOK, payerMaxResponse{Code: "INVALID_SIGNATURE", Msg: "sign verify failed"})
+		return
+	}
+
+	var req struct {
+		AppID      string          `json:"appId"`
+		MerchantNo string          `json:"merchantNo"`
+		Data       json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.MerchantNo != PayerMaxMerchantNo {
+		writeJSON(w, http.StatusOK, payerMaxResponse{Code: "PARAMS_INVALID", Msg: "invalid request"})
+		return
+	}
+
+	var data interface{}
+	switch r.URL.Path {
+	case "/" + payermax.Order:
+		data, err = s.payerMaxOrder(req.Data)
+	case "/" + payermax.OrderQuery:
+		data, err = s.payerMaxOrderQuery(req.Data)
+	case "/" + payermax.Refund:
+		data, err = s.payerMaxRefund(req.Data)
+	case "/" + payermax.RefundQuery:
+		data, err = s.payerMaxRefundQuery(req.Data)
+	case "/" + payermax.OutPayOrder:
+		data, err = s.payerMaxPayOut(req.Data)
+	case "/" + payermax.OutPayQuery:
+		data, err = s.payerMaxPayOutQuery(req.Data)
+	case "/" + payermax.CurrentBalanceQuery:
+		data = payermax.BalanceQueryResponseData{Currency: "USD", TotalBalance: "1000000", AvailableBalance: "1000000", FrozenBalance: "0"}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusOK, payerMaxResponse{Code: "ORDER_NOT_EXIST", Msg: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, payerMaxResponse{Code: "APPLY_SUCCESS", Msg: "Success", Data: data})
+}
+
+func (s *Server) payerMaxOrder(raw json.RawMessage) (interface{}, error) {
+	var req payermax.PayOrder
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+
+	order := s.create(&Order{
+		Type:      payment.NotifyPay,
+		Channel:   payment.ChannelPayerMax,
+		OrderId:   req.OutTradeNo,
+		Amount:    req.TotalAmount,
+		Currency:  req.Currency,
+		Status:    "PENDING",
+		NotifyUrl: req.NotifyURL,
+		UserId:    req.UserID,
+	})
+
+	return payermax.PayOrderResponseData{
+		OutTradeNo:  order.OrderId,
+		TradeToken:  order.TradeNo,
+		Status:      order.Status,
+		RedirectUrl: s.URL + payerMaxPrefix + "/cashier?tradeToken=" + order.TradeNo,
+	}, nil
+}
+
+func (s *Server) payerMaxOrderQuery(raw json.RawMessage) (interface{}, error) {
+	var req payermax.PayQuery
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+
+	order, ok := s.find(payment.NotifyPay, payment.ChannelPayerMax, req.OutTradeNo)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	res := payermax.PayQueryResponseData{
+		OutTradeNo: order.OrderId,
+		TradeNo:    order.TradeNo,
+		Status:     order.Status,
+	}
+	res.Trade.Amount = utils.Float64ToString(order.Amount)
+	res.Trade.Currency = order.Currency
+	return res, nil
+}
+
+// payerMaxRefund 退款直接成功并推送退款回调
+func (s *Server) payerMaxRefund(raw json.RawMessage) (interface{}, error) {
+	var req payermax.RefundRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	if _, ok := s.find(payment.NotifyPay, payment.ChannelPayerMax, req.OutTradeNo); !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	order := s.create(&Order{
+		Type:      payment.NotifyRefund,
+		Channel:   payment.ChannelPayerMax,
+		OrderId:   req.OutRefundNo,
+		Amount:    req.RefundAmount,
+		Currency:  req.RefundCurrency,
+		Status:    "REFUND_SUCCESS",
+		NotifyUrl: req.RefundNotifyUrl,
+		RelatedId: req.OutTradeNo,
+	})
+	s.update(payment.NotifyPay, payment.ChannelPayerMax, req.OutTradeNo, "REFUNDED")
+
+	s.notifyAsync(order)
+
+	return payermax.RefundResponseData{
+		OutRefundNo:   order.OrderId,
+		RefundTradeNo: order.TradeNo,
+		Status:        "REFUND_PENDING",
+	}, nil
+}
+
+func (s *Server) payerMaxRefundQuery(raw json.RawMessage) (interface{}, error) {
+	var req payermax.RefundQueryRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+
+	order, ok := s.find(payment.NotifyRefund, payment.ChannelPayerMax, req.OutRefundNo)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	return payermax.RefundQueryResponseData{
+		OutRefundNo:    order.OrderId,
+		RefundTradeNo:  order.TradeNo,
+		OutTradeNo:     order.RelatedId,
+		RefundAmount:   order.Amount,
+		RefundCurrency: order.Currency,
+		Status:         order.Status,
+	}, nil
+}
+
+func (s *Server) payerMaxPayOut(raw json.RawMessage) (interface{}, error) {
+	var req payermax.RemitRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+
+	order := s.create(&Order{
+		Type:      payment.NotifyPayout,
+		Channel:   payment.ChannelPayerMax,
+		OrderId:   req.OutTradeNo,
+		Amount:    utils.StringToFloat64(req.Trade.Amount),
+		Currency:  req.Trade.Currency,
+		Status:    "PENDING",
+		NotifyUrl: req.NotifyURL,
+	})
+
+	return payermax.RemitResponseData{
+		OutTradeNo: order.OrderId,
+		TradeNo:    order.TradeNo,
+		Status:     order.Status,
+	}, nil
+}
+
+func (s *Server) payerMaxPayOutQuery(raw json.RawMessage) (interface{}, error) {
+	var req payermax.PayOutQuery
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+
+	order, ok := s.find(payment.NotifyPayout, payment.ChannelPayerMax, req.OutTradeNo)
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+
+	res := payermax.PayOutQueryResponseData{
+		OutTradeNo: order.OrderId,
+		TradeNo:    order.TradeNo,
+		Status:     order.Status,
+	}
+	res.Trade.Amount = utils.Float64ToString(order.Amount)
+	res.Trade.Currency = order.Currency
+	return res, nil
+}
+
+// notifyPayerMax 推送使用平台私钥签名的回调，签名放在 sign header
+func (s *Server) notifyPayerMax(order *Order) error {
+	var notify interface{}
+	switch order.Type {
+	case payment.NotifyRefund:
+		n := payermax.RefundNotify{Code: "APPLY_SUCCESS", Msg: "", KeyVersion: "1", AppID: PayerMaxAppId, MerchantNo: PayerMaxMerchantNo, NotifyTime: time.Now().Format(time.RFC3339), NotifyType: payermax.NotifyTypeRefund}
+		n.Data.OutRefundNo = order.OrderId
+		n.Data.RefundTradeNo = order.TradeNo
+		n.Data.OutTradeNo = order.RelatedId
+		n.Data.RefundAmount = order.Amount
+		n.Data.RefundCurrency = order.Currency
+		n.Data.Status = order.Status
+		n.Data.CompleteTime = time.Now().Format(time.RFC3339)
+		notify = n
+	case payment.NotifyPayout:
+		n := payermax.PayOutNotify{Code: "APPLY_SUCCESS", Msg: "", KeyVersion: "1", AppID: PayerMaxAppId, MerchantNo: PayerMaxMerchantNo, NotifyTime: time.Now().Format(time.RFC3339), NotifyType: payermax.NotifyTypePayout}
+		n.Data.OutTradeNo = order.OrderId
+		n.Data.TradeNo = order.TradeNo
+		n.Data.Status = order.Status
+		n.Data.Trade.Amount = utils.Float64ToString(order.Amount)
+		n.Data.Trade.Currency = order.Currency
+		notify = n
+	default:
+		n := payermax.PayNotify{Code: "APPLY_SUCCESS", Msg: "", KeyVersion: "1", AppID: PayerMaxAppId, MerchantNo: PayerMaxMerchantNo, NotifyTime: time.Now(), NotifyType: payermax.NotifyTypePayment}
+		n.Data.OutTradeNo = order.OrderId
+		n.Data.TradeToken = order.TradeNo
+		n.Data.TotalAmount = order.Amount
+		n.Data.Currency = order.Currency
+		n.Data.Status = order.Status
+		notify = n
+	}
+
+	body, err := json.Marshal(notify)
+	if err != nil {
+		return err
+	}
+	sign, err := utils.SignRsa(string(body), s.platformKey)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("sign", sign)
+	return s.deliver(payment.ChannelPayerMax, order.NotifyUrl, body, header)
+}
@@ -0,0 +1,411 @@
+package paysim
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/httpHelper"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/payment"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 模拟网关使用的固定商户凭证，PayConfig 会返回对应配置
+const (
+	PayerMaxAppId      = "sim-app"
+	PayerMaxMerchantNo = "SIM0000001"
+	XenditSecretKey    = "xnd_development_paysim"
+	XenditVerifyToken  = "paysim-callback-token"
+	XenditBusinessId   = "paysim-business"
+	CodaApiKey         = "paysim-coda-key"
+	CodaCountry        = 360
+	CodaCurrency       = 360
+	BinanceApiKey      = "paysim-api-key"
+	BinanceSecretKey   = "paysim-secret-key"
+	BinanceCertSerial  = "paysim-cert-serial"
+)
+
+// 各渠道在模拟网关上的路径前缀
+const (
+	payerMaxPrefix = "/payermax"
+	xenditPrefix   = "/xendit"
+	codaPrefix     = "/coda"
+	binancePrefix  = "/binance"
+)
+
+var ErrOrderNotFound = errors.New("paysim: order not found")
+
+// Config 模拟网关配置
+type Config struct {
+	// NotifyUrl 各渠道默认的回调地址
+	NotifyUrl string
+	// NotifyUrls 按渠道覆盖回调地址
+	NotifyUrls map[payment.Channel]string
+	// AutoPay 下单后立即异步模拟支付成功并推送回调
+	AutoPay bool
+}
+
+// Order 网关内存中的订单、退款或代付单，Status 为渠道原始状态
+type Order struct {
+	Type      payment.NotifyType
+	Channel   payment.Channel
+	OrderId   string
+	TradeNo   string
+	Amount    float64
+	Currency  string
+	Status    string
+	NotifyUrl string
+	UserId    string
+	// RelatedId 退款对应的原订单号
+	RelatedId string
+	CreatedAt time.Time
+}
+
+// Delivery 一次回调推送记录
+type Delivery struct {
+	Channel    payment.Channel
+	Url        string
+	Body       []byte
+	StatusCode int
+	Err        error
+}
+
+// Server 进程内的假支付网关，接口路径与各渠道一致，订单状态保存在内存中
+type Server struct {
+	*httptest.Server
+
+	cfg    Config
+	client *httpHelper.Client
+
+	// payerMaxMerchantKey 商户签名私钥，网关用公钥验签
+	payerMaxMerchantKey *rsa.PrivateKey
+	// platformKey PayerMax 回调和 binance webhook 的平台签名私钥
+	platformKey *rsa.PrivateKey
+
+	seq        int64
+	mu         sync.Mutex
+	orders     map[string]*Order
+	deliveries []Delivery
+}
+
+// NewServer 启动模拟网关，使用完毕需调用 Close
+func NewServer(cfg *Config) (*Server, error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	merchantKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	platformKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:                 *cfg,
+		client:              httpHelper.NewClient(&httpHelper.ClientConfig{Timeout: 10 * time.Second}),
+		payerMaxMerchantKey: merchantKey,
+		platformKey:         platformKey,
+		orders:              make(map[string]*Order),
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(payerMaxPrefix+"/", http.StripPrefix(payerMaxPrefix, http.HandlerFunc(s.handlePayerMax)))
+	mux.Handle(xenditPrefix+"/", http.StripPrefix(xenditPrefix, http.HandlerFunc(s.handleXendit)))
+	mux.Handle(codaPrefix+"/", http.StripPrefix(codaPrefix, http.HandlerFunc(s.handleCoda)))
+	mux.Handle(binancePrefix+"/", http.StripPrefix(binancePrefix, http.HandlerFunc(s.handleBinance)))
+	s.Server = httptest.NewServer(mux)
+
+	return s, nil
+}
+
+// PayConfig 指向模拟网关的支付配置，可直接传给 payment.NewProvidersFromConfig 或各渠道 NewClient
+func (s *Server) PayConfig() *config.PayConfig {
+	merchantKey, _ := x509.MarshalPKCS8PrivateKey(s.payerMaxMerchantKey)
+
+	return &config.PayConfig{
+		PayerMax: config.PayerMaxConfig{
+			AppID:           PayerMaxAppId,
+			MerchantNo:      PayerMaxMerchantNo,
+			Url:             s.URL + payerMaxPrefix,
+			ReturnUrl:       s.URL + payerMaxPrefix + "/return",
+			NotifyUrl:       s.notifyUrl(payment.ChannelPayerMax),
+			PayOutNotifyUrl: s.notifyUrl(payment.ChannelPayerMax),
+			RefundNotifyUrl: s.notifyUrl(payment.ChannelPayerMax),
+			RSAPublicKey:    s.platformPublicKey(),
+			RSAPrivateKey:   base64.StdEncoding.EncodeToString(merchantKey),
+		},
+		Xendit: map[string]*config.XenditConfig{
+			"default": {
+				SecretKey:   XenditSecretKey,
+				VerifyToken: XenditVerifyToken,
+				BusinessId:  XenditBusinessId,
+				Url:         s.URL + xenditPrefix,
+			},
+		},
+		Coda: map[string]*config.CodaConfig{
+			"default": {
+				ApiKey:      CodaApiKey,
+				Country:     CodaCountry,
+				Currency:    CodaCurrency,
+				Url:         s.URL + codaPrefix + "/init/",
+				RedirectUrl: s.URL + codaPrefix + "/begin",
+			},
+		},
+		Binance: config.BinanceConfig{
+			ApiKey:    BinanceApiKey,
+			SecretKey: BinanceSecretKey,
+			Url:       s.URL + binancePrefix,
+		},
+	}
+}
+
+// platformPublicKey PEM 格式的平台公钥
+func (s *Server) platformPublicKey() string {
+	der, _ := x509.MarshalPKIXPublicKey(&s.platformKey.PublicKey)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func (s *Server) notifyUrl(channel payment.Channel) string {
+	if url, ok := s.cfg.NotifyUrls[channel]; ok {
+		return url
+	}
+	return s.cfg.NotifyUrl
+}
+
+// Order 查询订单当前状态
+func (s *Server) Order(t payment.NotifyType, channel payment.Channel, orderId string) (Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderKey(t, channel, orderId)]
+	if !ok {
+		return Order{}, false
+	}
+	return *order, true
+}
+
+// Deliveries 已推送的回调记录
+func (s *Server) Deliveries() []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := make([]Delivery, len(s.deliveries))
+	copy(deliveries, s.deliveries)
+	return deliveries
+}
+
+// Pay 模拟用户支付成功并同步推送签名回调
+func (s *Server) Pay(channel payment.Channel, orderId string) error {
+	return s.settle(payment.NotifyPay, channel, orderId, true)
+}
+
+// Fail 模拟支付失败/关闭并推送回调
+func (s *Server) Fail(channel payment.Channel, orderId string) error {
+	return s.settle(payment.NotifyPay, channel, orderId, false)
+}
+
+// SettlePayout 代付完成并推送回调，success 为 false 时推送失败回调
+func (s *Server) SettlePayout(channel payment.Channel, orderId string, success bool) error {
+	return s.settle(payment.NotifyPayout, channel, orderId, success)
+}
+
+func (s *Server) settle(t payment.NotifyType, channel payment.Channel, orderId string, success bool) error {
+	s.mu.Lock()
+	order, ok := s.orders[orderKey(t, channel, orderId)]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("%w: %s %s", ErrOrderNotFound, channel, orderId)
+	}
+	order.Status = finalStatus(t, channel, success)
+	snapshot := *order
+	s.mu.Unlock()
+
+	return s.notify(&snapshot)
+}
+
+// finalStatus 各渠道完成后的原始状态
+func finalStatus(t payment.NotifyType, channel payment.Channel, success bool) string {
+	switch channel {
+	case payment.ChannelPayerMax:
+		if success {
+			return "SUCCESS"
+		} else if t == payment.NotifyPay {
+			return "CLOSED"
+		}
+		return "FAILED"
+	case payment.ChannelXendit:
+		if t == payment.NotifyPayout {
+			if success {
+				return "SUCCEEDED"
+			}
+			return "FAILED"
+		}
+		if success {
+			return "PAID"
+		}
+		return "EXPIRED"
+	case payment.ChannelCoda:
+		if success {
+			return "SUCCESS"
+		}
+		return "FAILED"
+	case payment.ChannelBinance:
+		if t == payment.NotifyPayout {
+			if success {
+				return "SUCCESS"
+			}
+			return "FAILED"
+		}
+		if success {
+			return "PAID"
+		}
+		return "CANCELED"
+	}
+	return ""
+}
+
+func (s *Server) notify(order *Order) error {
+	switch order.Channel {
+	case payment.ChannelPayerMax:
+		return s.notifyPayerMax(order)
+	case payment.ChannelXendit:
+		return s.notifyXendit(order)
+	case payment.ChannelCoda:
+		return s.notifyCoda(order)
+	case payment.ChannelBinance:
+		return s.notifyBinance(order)
+	}
+	return fmt.Errorf("paysim: unsupported channel %s", order.Channel)
+}
+
+// create 保存新订单并返回快照，AutoPay 时异步模拟支付成功
+func (s *Server) create(order *Order) *Order {
+	order.CreatedAt = time.Now()
+	if order.NotifyUrl == "" {
+		order.NotifyUrl = s.notifyUrl(order.Channel)
+	}
+	if order.TradeNo == "" {
+		order.TradeNo = s.nextId(string(order.Channel))
+	}
+
+	s.mu.Lock()
+	s.orders[orderKey(order.Type, order.Channel, order.OrderId)] = order
+	snapshot := *order
+	s.mu.Unlock()
+
+	if s.cfg.AutoPay && snapshot.Type == payment.NotifyPay {
+		go func() {
+			if err := s.Pay(snapshot.Channel, snapshot.OrderId); err != nil {
+				logger.Warn("paysim", logger.String("text", "auto pay failed"), logger.String("orderId", snapshot.OrderId), logger.Err(err))
+			}
+		}()
+	}
+	return &snapshot
+}
+
+// notifyAsync 异步推送回调，用于退款等接口内直接完成的场景
+func (s *Server) notifyAsync(order *Order) {
+	snapshot := *order
+	go func() {
+		if err := s.notify(&snapshot); err != nil {
+			logger.Warn("paysim", logger.String("text", "notify failed"), logger.String("orderId", snapshot.OrderId), logger.Err(err))
+		}
+	}()
+}
+
+func (s *Server) find(t payment.NotifyType, channel payment.Channel, orderId string) (*Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderKey(t, channel, orderId)]
+	if !ok {
+		return nil, false
+	}
+	snapshot := *order
+	return &snapshot, true
+}
+
+// findBy 按条件查找订单，用于按网关单号查询
+func (s *Server) findBy(match func(*Order) bool) (*Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, order := range s.orders {
+		if match(order) {
+			snapshot := *order
+			return &snapshot, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) update(t payment.NotifyType, channel payment.Channel, orderId, status string) (*Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[orderKey(t, channel, orderId)]
+	if !ok {
+		return nil, false
+	}
+	order.Status = status
+	snapshot := *order
+	return &snapshot, true
+}
+
+func (s *Server) nextId(prefix string) string {
+	return fmt.Sprintf("%s%d%06d", strings.ToUpper(prefix[:2]), time.Now().Unix(), atomic.AddInt64(&s.seq, 1))
+}
+
+// deliver 推送回调并记录结果，非 200 视为失败
+func (s *Server) deliver(channel payment.Channel, url string, body []byte, header http.Header) error {
+	if url == "" {
+		return fmt.Errorf("paysim: %s notify url is empty", channel)
+	}
+
+	delivery := Delivery{Channel: channel, Url: url, Body: body}
+	res, err := s.client.Send(context.Background(), http.MethodPost, url, body, header)
+	if err == nil {
+		delivery.StatusCode = res.StatusCode
+		if res.StatusCode != http.StatusOK {
+			err = &httpHelper.StatusError{StatusCode: res.StatusCode, Status: res.Status, Body: res.Body}
+		}
+	}
+	delivery.Err = err
+
+	s.mu.Lock()
+	s.deliveries = append(s.deliveries, delivery)
+	s.mu.Unlock()
+
+	return err
+}
+
+func orderKey(t payment.NotifyType, channel payment.Channel, orderId string) string {
+	return fmt.Sprintf("%d:%s:%s", t, channel, orderId)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	return io.ReadAll(r.Body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,171 @@
+package paysim
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/binance"
+	"github.com/kmcqqq/pkg/coda"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/payermax"
+	"github.com/kmcqqq/pkg/payment"
+	"github.com/kmcqqq/pkg/xendit"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(&config.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+// callback 商户回调地址收到的原始请求
+type callback struct {
+	header http.Header
+	body   []byte
+}
+
+// payAndReceive 通过统一渠道下单，模拟支付成功，返回商户回调地址收到的请求
+func payAndReceive(t *testing.T, channel payment.Channel, account string, req *payment.OrderRequest) (*Server, callback) {
+	t.Helper()
+	received := make(chan callback, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- callback{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(receiver.Close)
+
+	sim, err := NewServer(&Config{NotifyUrl: receiver.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sim.Close)
+
+	providers, err := payment.NewProvidersFromConfig(sim.PayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := providers.Get(channel, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.CreateOrder(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Pay(channel, req.OrderId); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cb := <-received:
+		return sim, cb
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not received")
+	}
+	return nil, callback{}
+}
+
+func TestPayerMaxNotifyRoundTrip(t *testing.T) {
+	sim, cb := payAndReceive(t, payment.ChannelPayerMax, "", &payment.OrderRequest{
+		OrderId: "pm-1", Amount: payment.Amount{Value: 9.99, Currency: "USD"}, Subject: "coin", UserId: "1", Country: "US",
+	})
+
+	client, err := payermax.NewClient(sim.PayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notify, err := client.VerifyNotify(cb.body, cb.header.Get("sign"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notify.Pay == nil || notify.Pay.Data.OutTradeNo != "pm-1" || notify.Pay.Data.Status != "SUCCESS" {
+		t.Fatalf("unexpected notify %+v", notify.Pay)
+	}
+
+	tampered := append([]byte{}, cb.body...)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := client.VerifyNotify(tampered, cb.header.Get("sign")); !errors.Is(err, payermax.ErrInvalidSign) {
+		t.Fatalf("tampered body should fail, got %v", err)
+	}
+}
+
+func TestXenditCallbackRoundTrip(t *testing.T) {
+	sim, cb := payAndReceive(t, payment.ChannelXendit, "default", &payment.OrderRequest{
+		OrderId: "xnd-1", Amount: payment.Amount{Value: 10000, Currency: "IDR"}, Subject: "coin",
+	})
+
+	clients, err := xendit.NewClient(sim.PayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := clients["default"]
+
+	req := httptest.NewRequest(http.MethodPost, "/notify", bytes.NewReader(cb.body))
+	req.Header = cb.header
+	callback, err := client.VerifyCallback(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if callback.Invoice == nil || callback.Invoice.ExternalID != "xnd-1" || callback.Invoice.Status != "PAID" {
+		t.Fatalf("unexpected callback %+v", callback.Invoice)
+	}
+
+	if _, err := client.VerifyCallbackBody("wrong-token", cb.body); !errors.Is(err, xendit.ErrInvalidToken) {
+		t.Fatalf("wrong token should fail, got %v", err)
+	}
+}
+
+func TestCodaNotifyRoundTrip(t *testing.T) {
+	sim, cb := payAndReceive(t, payment.ChannelCoda, "default", &payment.OrderRequest{
+		OrderId: "coda-1", Amount: payment.Amount{Value: 15000, Currency: "IDR"}, Subject: "coin", UserId: "1", Quantity: 100,
+	})
+
+	clients, err := coda.NewClient(sim.PayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := clients["default"]
+
+	notify, err := client.VerifyNotify(cb.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notify.OrderId != "coda-1" || notify.ResultCode != "0" {
+		t.Fatalf("unexpected notify %+v", notify)
+	}
+
+	other := *client
+	other.ApiKey = "other-key"
+	if _, err := other.VerifyNotify(cb.body); !errors.Is(err, coda.ErrInvalidSign) {
+		t.Fatalf("wrong api key should fail, got %v", err)
+	}
+}
+
+func TestBinanceWebhookRoundTrip(t *testing.T) {
+	sim, cb := payAndReceive(t, payment.ChannelBinance, "", &payment.OrderRequest{
+		OrderId: "bn-1", Amount: payment.Amount{Value: 5, Currency: "USDT"}, Subject: "coin",
+	})
+
+	client, err := binance.NewClient(sim.PayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := client.ParseWebhook(cb.header, cb.body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Pay == nil || event.Pay.Data.MerchantTradeNo != "bn-1" || event.Pay.BizStatus != "PAY_SUCCESS" {
+		t.Fatalf("unexpected event %+v", event.Pay)
+	}
+
+	header := cb.header.Clone()
+	header.Set("BinancePay-Nonce", "tampered")
+	if _, err := client.ParseWebhook(header, cb.body); !errors.Is(err, binance.ErrInvalidSignature) {
+		t.Fatalf("tampered nonce should fail, got %v", err)
+	}
+}
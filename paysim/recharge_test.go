@@ -0,0 +1,109 @@
+package paysim
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/payermax"
+	"github.com/kmcqqq/pkg/payment"
+	"github.com/kmcqqq/pkg/queue"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestRechargeSuccessEndToEnd 模拟支付回调经商户处理后发出充值成功通知
+func TestRechargeSuccessEndToEnd(t *testing.T) {
+	q := queue.NewMemory()
+	defer q.Close()
+	err := q.Declare(&config.QueueTopology{
+		Queues:   []config.QueueSpec{{Name: "gateway"}},
+		Bindings: []config.BindingSpec{{Queue: "gateway", Exchange: queue.NotifyExchange}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := q.ConsumeMessages("gateway")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := queue.NewMessageService(q)
+
+	var client *payermax.Client
+	merchant := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		notify, err := client.VerifyNotify(body, r.Header.Get("sign"))
+		if err != nil || notify.Pay == nil || notify.Pay.Data.Status != "SUCCESS" {
+			t.Errorf("verify notify: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data := notify.Pay.Data
+		if err := messages.RechargeSuccess(10001, 500, data.TotalAmount, 1, 7, 0, data.Currency, true, data.OutTradeNo); err != nil {
+			t.Errorf("recharge success: %v", err)
+		}
+		payermax.WriteNotifyAck(w)
+	}))
+	defer merchant.Close()
+
+	sim, err := NewServer(&Config{NotifyUrl: merchant.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	if client, err = payermax.NewClient(sim.PayConfig()); err != nil {
+		t.Fatal(err)
+	}
+	providers, err := payment.NewProvidersFromConfig(sim.PayConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := providers.Get(payment.ChannelPayerMax, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := &payment.OrderRequest{
+		OrderId: "pm-e2e", Amount: payment.Amount{Value: 4.99, Currency: "USD"}, Subject: "coin", UserId: "10001", Country: "US",
+	}
+	if _, err := provider.CreateOrder(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Pay(payment.ChannelPayerMax, order.OrderId); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg queue.Delivery
+	select {
+	case msg = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recharge message not published")
+	}
+	if msg.Exchange != queue.NotifyExchange {
+		t.Fatalf("exchange = %s", msg.Exchange)
+	}
+
+	var notify struct {
+		Code int `json:"code"`
+		Data struct {
+			UserIdx    int64   `json:"useridx"`
+			Cash       int64   `json:"cash"`
+			Money      float64 `json:"money"`
+			Dtype      int     `json:"dtype"`
+			ProductId  int     `json:"productId"`
+			Currency   string  `json:"currency"`
+			IsFirstPay bool    `json:"isFirstPay"`
+			OrderId    string  `json:"orderId"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(msg.Body, &notify); err != nil {
+		t.Fatal(err)
+	}
+	data := notify.Data
+	if notify.Code != 101 || data.UserIdx != 10001 || data.Cash != 500 || data.Money != 4.99 ||
+		data.Currency != "USD" || data.ProductId != 7 || !data.IsFirstPay || data.OrderId != "pm-e2e" {
+		t.Fatalf("unexpected recharge message %s", msg.Body)
+	}
+}
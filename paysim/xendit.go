@@ -0,0 +1,213 @@
+package paysim
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kmcqqq/pkg/payment"
+	"github.com/kmcqqq/pkg/utils"
+	"github.com/kmcqqq/pkg/xendit"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type xenditError struct {
+	ErrorCode string `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// handleXendit 校验 Basic 认证后按路径分发，路径与 xendit.Client 一致
+func (s *Server) handleXendit(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(XenditSecretKey)) {
+		writeJSON(w, http.StatusUnauthorized, xenditError{ErrorCode: "INVALID_API_KEY", Message: "API key is invalid"})
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && path == "/v2/invoices":
+		s.xenditCreateInvoice(w, r)
+	case r.Method == http.MethodGet && strings.TrimSuffix(path, "/") == "/v2/invoices":
+		s.xenditGetInvoices(w, r)
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/invoices/") && strings.HasSuffix(path, "/expire!"):
+		s.xenditExpireInvoice(w, strings.TrimSuffix(strings.TrimPrefix(path, "/invoices/"), "/expire!"))
+	case r.Method == http.MethodPost && path == "/v2/payouts":
+		s.xenditCreatePayout(w, r)
+	case r.Method == http.MethodGet && path == "/v2/payouts":
+		s.xenditGetPayouts(w, r.URL.Query().Get("reference_id"))
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/v2/payouts/") && strings.HasSuffix(path, "/cancel"):
+		s.xenditCancelPayout(w, strings.TrimSuffix(strings.TrimPrefix(path, "/v2/payouts/"), "/cancel"))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/v2/payouts/"):
+		s.xenditGetPayout(w, strings.TrimPrefix(path, "/v2/payouts/"))
+	case r.Method == http.MethodGet && path == "/balance":
+		writeJSON(w, http.StatusOK, xendit.BalanceResponse{Balance: 1000000})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) xenditCreateInvoice(w http.ResponseWriter, r *http.Request) {
+	var req xendit.InvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, xenditError{ErrorCode: "API_VALIDATION_ERROR", Message: err.Error()})
+		return
+	}
+
+	order := s.create(&Order{
+		Type:     payment.NotifyPay,
+		Channel:  payment.ChannelXendit,
+		OrderId:  req.ExternalId,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Status:   "PENDING",
+	})
+	writeJSON(w, http.StatusOK, s.xenditInvoice(order))
+}
+
+func (s *Server) xenditGetInvoices(w http.ResponseWriter, r *http.Request) {
+	invoices := []xendit.InvoiceResponse{}
+	if order, ok := s.find(payment.NotifyPay, payment.ChannelXendit, r.URL.Query().Get("external_id")); ok {
+		invoices = append(invoices, *s.xenditInvoice(order))
+	}
+	writeJSON(w, http.StatusOK, invoices)
+}
+
+func (s *Server) xenditExpireInvoice(w http.ResponseWriter, id string) {
+	order, ok := s.xenditFindByTradeNo(payment.NotifyPay, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, xenditError{ErrorCode: "INVOICE_NOT_FOUND_ERROR", Message: "Invoice not found"})
+		return
+	}
+	if order.Status != "PENDING" {
+		writeJSON(w, http.StatusBadRequest, xenditError{ErrorCode: "INVALID_STATUS", Message: "Invoice is not pending"})
+		return
+	}
+
+	order, _ = s.update(payment.NotifyPay, payment.ChannelXendit, order.OrderId, "EXPIRED")
+	writeJSON(w, http.StatusOK, s.xenditInvoice(order))
+}
+
+func (s *Server) xenditCreatePayout(w http.ResponseWriter, r *http.Request) {
+	var req xendit.PayoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, xenditError{ErrorCode: "API_VALIDATION_ERROR", Message: err.Error()})
+		return
+	}
+
+	order := s.create(&Order{
+		Type:     payment.NotifyPayout,
+		Channel:  payment.ChannelXendit,
+		OrderId:  req.ReferenceID,
+		Amount:   req.Amount,
+		Currency: req.Currency,
+		Status:   "ACCEPTED",
+	})
+	writeJSON(w, http.StatusOK, xenditPayout(order))
+}
+
+func (s *Server) xenditGetPayouts(w http.ResponseWriter, referenceId string) {
+	payouts := []xendit.PayoutResponse{}
+	if order, ok := s.find(payment.NotifyPayout, payment.ChannelXendit, referenceId); ok {
+		payouts = append(payouts, *xenditPayout(order))
+	}
+	writeJSON(w, http.StatusOK, payouts)
+}
+
+func (s *Server) xenditGetPayout(w http.ResponseWriter, id string) {
+	order, ok := s.xenditFindByTradeNo(payment.NotifyPayout, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, xenditError{ErrorCode: "DATA_NOT_FOUND", Message: "Payout not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, xenditPayout(order))
+}
+
+func (s *Server) xenditCancelPayout(w http.ResponseWriter, id string) {
+	order, ok := s.xenditFindByTradeNo(payment.NotifyPayout, id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, xenditError{ErrorCode: "DATA_NOT_FOUND", Message: "Payout not found"})
+		return
+	}
+	if order.Status != "ACCEPTED" {
+		writeJSON(w, http.StatusBadRequest, xenditError{ErrorCode: "PAYOUT_NOT_CANCELLABLE", Message: "Payout is not cancellable"})
+		return
+	}
+
+	order, _ = s.update(payment.NotifyPayout, payment.ChannelXendit, order.OrderId, "CANCELLED")
+	writeJSON(w, http.StatusOK, xenditPayout(order))
+}
+
+func (s *Server) xenditFindByTradeNo(t payment.NotifyType, tradeNo string) (*Order, bool) {
+	return s.findBy(func(order *Order) bool {
+		return order.Type == t && order.Channel == payment.ChannelXendit && order.TradeNo == tradeNo
+	})
+}
+
+func (s *Server) xenditInvoice(order *Order) *xendit.InvoiceResponse {
+	return &xendit.InvoiceResponse{
+		ID:         order.TradeNo,
+		UserId:     XenditBusinessId,
+		ExternalID: order.OrderId,
+		Status:     order.Status,
+		Amount:     order.Amount,
+		Currency:   order.Currency,
+		ExpiryDate: order.CreatedAt.Add(24 * time.Hour),
+		InvoiceURL: s.URL + xenditPrefix + "/web/" + order.TradeNo,
+	}
+}
+
+func xenditPayout(order *Order) *xendit.PayoutResponse {
+	return &xendit.PayoutResponse{
+		ID:          order.TradeNo,
+		Amount:      order.Amount,
+		Currency:    order.Currency,
+		ReferenceID: order.OrderId,
+		Status:      order.Status,
+		Created:     order.CreatedAt,
+		Updated:     time.Now(),
+		BusinessID:  XenditBusinessId,
+	}
+}
+
+// notifyXendit 推送带 x-callback-token 的回调，发票回调没有 event 字段
+func (s *Server) notifyXendit(order *Order) error {
+	var notify interface{}
+	if order.Type == payment.NotifyPayout {
+		event := "payout.succeeded"
+		if order.Status != "SUCCEEDED" {
+			event = "payout.failed"
+		}
+		notify = xendit.PayOutNotify{
+			Event:      event,
+			BusinessId: XenditBusinessId,
+			Created:    time.Now().Format(time.RFC3339),
+			Data:       *xenditPayout(order),
+		}
+	} else {
+		callback := xendit.InvoiceCallback{
+			ID:         order.TradeNo,
+			ExternalID: order.OrderId,
+			UserID:     XenditBusinessId,
+			Status:     order.Status,
+			Amount:     order.Amount,
+			Currency:   order.Currency,
+			Created:    order.CreatedAt.Format(time.RFC3339),
+			Updated:    time.Now().Format(time.RFC3339),
+		}
+		if order.Status == "PAID" {
+			callback.PaidAmount = order.Amount
+			callback.PaidAt = time.Now().Format(time.RFC3339)
+		}
+		notify = callback
+	}
+
+	body, err := json.Marshal(notify)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("x-callback-token", XenditVerifyToken)
+	return s.deliver(payment.ChannelXendit, order.NotifyUrl, body, header)
+}
@@ -0,0 +1,200 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/streadway/amqp"
+	"sync"
+	"time"
+)
+
+const defaultConfirmTimeout = 5 * time.Second
+
+var (
+	// ErrNacked broker 拒绝了消息（nack）
+	ErrNacked = errors.New("queue: message nacked by broker")
+	// ErrConfirmTimeout 等待确认超时，消息可能已投递也可能丢失
+	ErrConfirmTimeout = errors.New("queue: publisher confirm timeout")
+	// ErrConfirmLost 收到确认前通道已关闭，消息可能已投递也可能丢失
+	ErrConfirmLost = errors.New("queue: channel closed before publisher confirm")
+)
+
+// ReturnError mandatory 消息无法路由，被 broker 退回
+type ReturnError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func (e *ReturnError) Error() string {
+	return fmt.Sprintf("queue: message returned by broker: exchange=%s routingKey=%s code=%d %s", e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+// confirmTracker 发布通道的确认跟踪，通道开启 confirm 模式后每条消息按 delivery tag 等待 ack/nack，
+// basic.return 按 MessageId 对应到等待者；没有等待者的消息在 nack 或被退回时记录日志
+type confirmTracker struct {
+	mu      sync.Mutex
+	nextTag uint64
+	waiters map[uint64]*confirmWaiter
+	ids     map[string]uint64
+	closed  bool
+}
+
+type confirmWaiter struct {
+	done      chan error
+	messageId string
+}
+
+// newConfirmTracker 把通道切换为 confirm 模式，切换后不能关闭，之后该通道上的每条发布都需经过 reserve
+func newConfirmTracker(channel *amqp.Channel) (*confirmTracker, error) {
+	if err := channel.Confirm(false); err != nil {
+		return nil, fmt.Errorf("failed to enable publisher confirm: %w", err)
+	}
+
+	t := &confirmTracker{
+		waiters: make(map[uint64]*confirmWaiter),
+		ids:     make(map[string]uint64),
+	}
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 64))
+	returns := channel.NotifyReturn(make(chan amqp.Return, 64))
+	go t.run(confirms, returns)
+	return t, nil
+}
+
+// reserve 返回下一条消息的 tag，wait 为 true 时注册等待者；调用方需持有发布锁，发布成功后调用 published
+func (t *confirmTracker) reserve(wait bool, messageId string) (uint64, chan error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tag := t.nextTag + 1
+	if !wait {
+		return tag, nil
+	}
+
+	done := make(chan error, 1)
+	if t.closed {
+		done <- ErrConfirmLost
+		return tag, done
+	}
+	t.waiters[tag] = &confirmWaiter{done: done, messageId: messageId}
+	if messageId != "" {
+		t.ids[messageId] = tag
+	}
+	return tag, done
+}
+
+func (t *confirmTracker) published() {
+	t.mu.Lock()
+	t.nextTag++
+	t.mu.Unlock()
+}
+
+func (t *confirmTracker) cancel(tag uint64) {
+	t.mu.Lock()
+	t.removeLocked(tag)
+	t.mu.Unlock()
+}
+
+func (t *confirmTracker) removeLocked(tag uint64) *confirmWaiter {
+	w, ok := t.waiters[tag]
+	if !ok {
+		return nil
+	}
+	delete(t.waiters, tag)
+	if t.ids[w.messageId] == tag {
+		delete(t.ids, w.messageId)
+	}
+	return w
+}
+
+// lookup 按 MessageId 找到等待中的 tag
+func (t *confirmTracker) lookup(messageId string) (uint64, bool) {
+	if messageId == "" {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tag, ok := t.ids[messageId]
+	return tag, ok
+}
+
+// run broker 对无法路由的 mandatory 消息先发 basic.return 再发 ack，因此处理确认前先取完已到达的 return
+func (t *confirmTracker) run(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	returned := make(map[uint64]*ReturnError)
+	onReturn := func(ret amqp.Return) {
+		retErr := &ReturnError{Exchange: ret.Exchange, RoutingKey: ret.RoutingKey, ReplyCode: ret.ReplyCode, ReplyText: ret.ReplyText}
+		tag, ok := t.lookup(ret.MessageId)
+		if !ok {
+			logger.Warn("rabbitmq", logger.String("text", "unmatched returned message"), logger.String("messageId", ret.MessageId), logger.Err(retErr))
+			return
+		}
+		returned[tag] = retErr
+	}
+
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			onReturn(ret)
+		case confirm, ok := <-confirms:
+			if !ok {
+				t.close()
+				return
+			}
+
+		drain:
+			for {
+				select {
+				case ret, ok := <-returns:
+					if !ok {
+						returns = nil
+						break drain
+					}
+					onReturn(ret)
+				default:
+					break drain
+				}
+			}
+
+			var err error
+			if retErr, ok := returned[confirm.DeliveryTag]; ok {
+				delete(returned, confirm.DeliveryTag)
+				err = retErr
+			} else if !confirm.Ack {
+				err = ErrNacked
+			}
+			t.resolve(confirm.DeliveryTag, err)
+		}
+	}
+}
+
+func (t *confirmTracker) resolve(tag uint64, err error) {
+	t.mu.Lock()
+	w := t.removeLocked(tag)
+	t.mu.Unlock()
+
+	if w != nil {
+		w.done <- err
+	} else if err != nil {
+		logger.Error("rabbitmq", logger.String("text", "publish not confirmed"), logger.Int64("tag", int64(tag)), logger.Err(err))
+	}
+}
+
+// close 通道关闭，未确认的等待者返回 ErrConfirmLost
+func (t *confirmTracker) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for tag, w := range t.waiters {
+		w.done <- ErrConfirmLost
+		delete(t.waiters, tag)
+	}
+	t.ids = make(map[string]uint64)
+}
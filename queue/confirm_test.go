@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+)
+
+func newTestTracker() (*confirmTracker, chan amqp.Confirmation, chan amqp.Return) {
+	t := &confirmTracker{waiters: make(map[uint64]*confirmWaiter), ids: make(map[string]uint64)}
+	confirms := make(chan amqp.Confirmation, 4)
+	returns := make(chan amqp.Return, 4)
+	go t.run(confirms, returns)
+	return t, confirms, returns
+}
+
+func TestConfirmTrackerMatchesReturnByMessageId(t *testing.T) {
+	tracker, confirms, returns := newTestTracker()
+	defer close(confirms)
+
+	tag1, done1 := tracker.reserve(true, "m-1")
+	tracker.published()
+	tag2, done2 := tracker.reserve(true, "m-2")
+	tracker.published()
+
+	// broker 对无法路由的消息先发 return 再发 ack
+	returns <- amqp.Return{MessageId: "m-2", Exchange: "notify", ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	confirms <- amqp.Confirmation{DeliveryTag: tag1, Ack: true}
+	confirms <- amqp.Confirmation{DeliveryTag: tag2, Ack: true}
+
+	if err := <-done1; err != nil {
+		t.Fatalf("m-1: %v", err)
+	}
+	var retErr *ReturnError
+	if err := <-done2; !errors.As(err, &retErr) || retErr.ReplyCode != 312 {
+		t.Fatalf("m-2: expected ReturnError, got %v", err)
+	}
+}
+
+func TestConfirmTrackerNackAndClose(t *testing.T) {
+	tracker, confirms, _ := newTestTracker()
+
+	tag, done := tracker.reserve(true, "m-1")
+	tracker.published()
+	_, lost := tracker.reserve(true, "m-2")
+	tracker.published()
+
+	confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: false}
+	if err := <-done; !errors.Is(err, ErrNacked) {
+		t.Fatalf("expected ErrNacked, got %v", err)
+	}
+
+	close(confirms)
+	if err := <-lost; !errors.Is(err, ErrConfirmLost) {
+		t.Fatalf("expected ErrConfirmLost, got %v", err)
+	}
+}
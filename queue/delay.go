@@ -0,0 +1,114 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/streadway/amqp"
+	"sort"
+	"time"
+)
+
+const (
+	// DelayStrategyPlugin 使用 rabbitmq_delayed_message_exchange 插件的 x-delayed-message 交换机
+	DelayStrategyPlugin = "plugin"
+	// DelayStrategyTTL 按延迟分桶的 TTL 队列，过期后死信回 DelayExchange，延迟向上取整到桶
+	DelayStrategyTTL = "ttl"
+)
+
+var defaultDelayBuckets = []time.Duration{
+	1 * time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	1 * time.Minute, 5 * time.Minute, 10 * time.Minute, 30 * time.Minute, 1 * time.Hour,
+}
+
+// delayBuckets 升序的桶，未配置使用默认值
+func (r *RabbitMQ) delayBuckets() []time.Duration {
+	if len(r.cfg.DelayBuckets) == 0 {
+		return defaultDelayBuckets
+	}
+	buckets := make([]time.Duration, 0, len(r.cfg.DelayBuckets))
+	for _, b := range r.cfg.DelayBuckets {
+		if b > 0 {
+			buckets = append(buckets, b)
+		}
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	return buckets
+}
+
+func (r *RabbitMQ) useTTLDelay() bool {
+	return r.cfg.DelayStrategy == DelayStrategyTTL
+}
+
+// bucketFor 不小于 delay 的最小桶，超过最大桶时返回最大桶；buckets 需升序且非空
+func bucketFor(buckets []time.Duration, delay time.Duration) time.Duration {
+	i := sort.Search(len(buckets), func(i int) bool { return buckets[i] >= delay })
+	if i == len(buckets) {
+		i--
+	}
+	return buckets[i]
+}
+
+func bucketName(bucket time.Duration) string {
+	return fmt.Sprintf("%s.ttl.%d", DelayExchange, bucket/time.Millisecond)
+}
+
+// bucketTopology 每个桶一个 fanout 交换机和同名 TTL 队列，队列不设置 dead-letter routing key，
+// 过期后保留发布时的 routing key 死信到 DelayExchange（此时为普通 direct 交换机）
+func (r *RabbitMQ) bucketTopology() *config.QueueTopology {
+	topology := &config.QueueTopology{
+		Exchanges: []config.ExchangeSpec{{Name: DelayExchange, Kind: amqp.ExchangeDirect, Durable: true}},
+	}
+	for _, bucket := range r.delayBuckets() {
+		name := bucketName(bucket)
+		topology.Exchanges = append(topology.Exchanges, config.ExchangeSpec{Name: name, Kind: amqp.ExchangeFanout, Durable: true})
+		topology.Queues = append(topology.Queues, config.QueueSpec{Name: name, Durable: true, MessageTTL: bucket, DeadLetterExchange: DelayExchange})
+		topology.Bindings = append(topology.Bindings, config.BindingSpec{Queue: name, Exchange: name})
+	}
+	return topology
+}
+
+// adaptTopology TTL 策略下 x-delayed-message 交换机按 DelayedType 声明为普通交换机，DefaultTopology 可在两种策略下共用
+func (r *RabbitMQ) adaptTopology(topology *config.QueueTopology) *config.QueueTopology {
+	if topology == nil || !r.useTTLDelay() {
+		return topology
+	}
+
+	adapted := *topology
+	adapted.Exchanges = make([]config.ExchangeSpec, len(topology.Exchanges))
+	for i, spec := range topology.Exchanges {
+		if spec.Kind == delayedMessageKind {
+			spec.Kind = spec.DelayedType
+			if spec.Kind == "" {
+				spec.Kind = amqp.ExchangeDirect
+			}
+			spec.DelayedType = ""
+		}
+		adapted.Exchanges[i] = spec
+	}
+	return &adapted
+}
+
+// publishDelayed 按配置的策略延迟投递到 DelayExchange 上以 routingKey 绑定的队列
+func (r *RabbitMQ) publishDelayed(routingKey string, msg amqp.Publishing, delay time.Duration, wait bool) error {
+	headers := make(amqp.Table, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers["x-delay"] = int(delay / time.Millisecond)
+	msg.Headers = headers
+
+	// 延迟插件交换机在发布时不路由，mandatory 会导致消息总被退回，因此不设置 mandatory
+	if !r.useTTLDelay() {
+		return r.publish(DelayExchange, routingKey, false, wait, msg)
+	}
+	if delay <= 0 {
+		return r.publish(DelayExchange, routingKey, true, wait, msg)
+	}
+
+	bucket := bucketFor(r.delayBuckets(), delay)
+	if delay > bucket {
+		logger.Warn("rabbitmq", logger.String("text", "delay exceeds largest bucket"), logger.Duration("delay", delay), logger.Duration("bucket", bucket))
+	}
+	return r.publish(bucketName(bucket), routingKey, true, wait, msg)
+}
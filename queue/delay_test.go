@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"github.com/kmcqqq/pkg/config"
+	"github.com/streadway/amqp"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucketFor(t *testing.T) {
+	buckets := defaultDelayBuckets
+	cases := []struct {
+		delay, want time.Duration
+	}{
+		{1 * time.Millisecond, 1 * time.Second},
+		{1 * time.Second, 1 * time.Second},
+		{1001 * time.Millisecond, 5 * time.Second},
+		{45 * time.Second, 1 * time.Minute},
+		{1 * time.Hour, 1 * time.Hour},
+		{3 * time.Hour, 1 * time.Hour},
+	}
+	for _, tc := range cases {
+		if got := bucketFor(buckets, tc.delay); got != tc.want {
+			t.Errorf("bucketFor(%s) = %s, want %s", tc.delay, got, tc.want)
+		}
+	}
+}
+
+func TestDelayBuckets(t *testing.T) {
+	r := NewRabbitMQ(&config.QueueConfig{DelayStrategy: DelayStrategyTTL})
+	if !reflect.DeepEqual(r.delayBuckets(), defaultDelayBuckets) {
+		t.Fatal("empty DelayBuckets should use defaults")
+	}
+
+	r = NewRabbitMQ(&config.QueueConfig{
+		DelayStrategy: DelayStrategyTTL,
+		DelayBuckets:  []time.Duration{time.Minute, 0, 10 * time.Second, -time.Second},
+	})
+	want := []time.Duration{10 * time.Second, time.Minute}
+	if got := r.delayBuckets(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("delayBuckets = %v, want %v", got, want)
+	}
+	if bucketName(10*time.Second) != DelayExchange+".ttl.10000" {
+		t.Fatalf("unexpected bucket name %s", bucketName(10*time.Second))
+	}
+
+	topology := r.bucketTopology()
+	if len(topology.Exchanges) != 3 || topology.Exchanges[0].Name != DelayExchange || topology.Exchanges[0].Kind != amqp.ExchangeDirect {
+		t.Fatalf("unexpected exchanges %+v", topology.Exchanges)
+	}
+	for i, bucket := range want {
+		name := bucketName(bucket)
+		q := topology.Queues[i]
+		if q.Name != name || q.MessageTTL != bucket || q.DeadLetterExchange != DelayExchange || q.DeadLetterRoutingKey != "" {
+			t.Errorf("unexpected bucket queue %+v", q)
+		}
+		if ex := topology.Exchanges[i+1]; ex.Name != name || ex.Kind != amqp.ExchangeFanout {
+			t.Errorf("unexpected bucket exchange %+v", ex)
+		}
+		if b := topology.Bindings[i]; b.Queue != name || b.Exchange != name {
+			t.Errorf("unexpected bucket binding %+v", b)
+		}
+	}
+	if args := queueArgs(topology.Queues[0]); args["x-message-ttl"] != int64(10000) || args["x-dead-letter-exchange"] != DelayExchange {
+		t.Fatalf("unexpected queue args %v", args)
+	}
+}
+
+func TestAdaptTopology(t *testing.T) {
+	plugin := NewRabbitMQ(nil)
+	if plugin.adaptTopology(DefaultTopology()).Exchanges[1].Kind != delayedMessageKind {
+		t.Fatal("plugin strategy should keep x-delayed-message")
+	}
+
+	ttl := NewRabbitMQ(&config.QueueConfig{DelayStrategy: DelayStrategyTTL})
+	original := DefaultTopology()
+	adapted := ttl.adaptTopology(original)
+	delay := adapted.Exchanges[1]
+	if delay.Name != DelayExchange || delay.Kind != amqp.ExchangeDirect || delay.DelayedType != "" {
+		t.Fatalf("unexpected adapted exchange %+v", delay)
+	}
+	if original.Exchanges[1].Kind != delayedMessageKind {
+		t.Fatal("adaptTopology must not modify the input")
+	}
+}
+
+func TestInitRejectsEmptyDelayBuckets(t *testing.T) {
+	r := NewRabbitMQ(&config.QueueConfig{
+		DelayStrategy: DelayStrategyTTL,
+		DelayBuckets:  []time.Duration{0, -time.Second},
+	})
+	// 校验在连接之前，无需 broker
+	if err := r.Init(&config.ServerInfo{Host: "127.0.0.1", Port: 1}); err == nil || !strings.Contains(err.Error(), "delay buckets") {
+		t.Fatalf("expected delay buckets error, got %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/streadway/amqp"
+	"sync"
+	"time"
+)
+
+// Memory 进程内 Queue 实现，用于单元测试和本地开发。
+// 支持 direct/fanout/topic 交换机、默认交换机按队列名路由和延迟投递，不支持 TTL、死信和持久化
+type Memory struct {
+	mu     sync.Mutex
+	router *router
+	queues map[string]*memoryQueue
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ Queue = &Memory{}
+var _ ConfirmPublisher = &Memory{}
+
+// NewMemory 创建内存队列，预置 amq.direct/amq.fanout/amq.topic、fanout 的 NotifyExchange 和 DelayExchange
+func NewMemory() *Memory {
+	return &Memory{
+		router: newRouter(false),
+		queues: make(map[string]*memoryQueue),
+		done:   make(chan struct{}),
+	}
+}
+
+// Init 内存实现无需连接
+func (m *Memory) Init(cfg *config.ServerInfo) error {
+	return nil
+}
+
+// Declare 声明交换机、队列和绑定，语义与 RabbitMQ.Declare 一致，队列参数（TTL、死信等）被忽略
+func (m *Memory) Declare(topology *config.QueueTopology) error {
+	if topology == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 声明中途出错时已声明的队列也要创建，保持与 router 一致
+	err := m.router.declare(topology)
+	for name := range m.router.queues {
+		if _, ok := m.queues[name]; !ok {
+			m.queues[name] = newMemoryQueue()
+		}
+	}
+	return err
+}
+
+func (m *Memory) PublishMessageByExchange(exchangeName, routingKey, message string) error {
+	n, err := m.route(exchangeName, routingKey, nil, []byte(message))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		logger.Warn("memory queue", logger.String("text", "unroutable message"), logger.String("exchange", exchangeName), logger.String("key", routingKey))
+	}
+	return nil
+}
+
+// PublishMessageConfirm 无法路由时返回 ReturnError
+func (m *Memory) PublishMessageConfirm(exchangeName, routingKey, message string) error {
+	n, err := m.route(exchangeName, routingKey, nil, []byte(message))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &ReturnError{Exchange: exchangeName, RoutingKey: routingKey, ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE"}
+	}
+	return nil
+}
+
+// PublishDelayMessage 到期后经 DelayExchange 路由
+func (m *Memory) PublishDelayMessage(routingKey, message string, delayTime time.Duration) error {
+	if m.isClosed() {
+		return ErrClosed
+	}
+
+	headers := map[string]interface{}{"x-delay": int(delayTime / time.Millisecond)}
+	time.AfterFunc(delayTime, func() {
+		if m.isClosed() {
+			return
+		}
+		if n, err := m.route(DelayExchange, routingKey, headers, []byte(message)); err != nil || n == 0 {
+			logger.Warn("memory queue", logger.String("text", "delayed message dropped"), logger.String("key", routingKey), logger.Err(err))
+		}
+	})
+	return nil
+}
+
+// PublishDelayMessageConfirm 与 PublishDelayMessage 相同，到期前消息只保存在内存中
+func (m *Memory) PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error {
+	return m.PublishDelayMessage(routingKey, message, delayTime)
+}
+
+// route 按交换机类型投递到匹配的队列，返回投递的队列数
+func (m *Memory) route(exchange, key string, headers map[string]interface{}, body []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isClosed() {
+		return 0, ErrClosed
+	}
+
+	targets, err := m.router.route(exchange, key)
+	if err != nil {
+		return 0, err
+	}
+	id := uuid.New().String()
+	for _, name := range targets {
+		m.queues[name].push(&memoryMessage{id: id, exchange: exchange, key: key, headers: headers, body: body})
+	}
+	return len(targets), nil
+}
+
+func (m *Memory) queue(name string) (*memoryQueue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[name]
+	if !ok {
+		return nil, fmt.Errorf("queue: queue %s not found", name)
+	}
+	return q, nil
+}
+
+func (m *Memory) ConsumeMessages(queueName string) (<-chan Delivery, error) {
+	q, err := m.queue(queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			msg, ok := q.pop(m.done)
+			if !ok {
+				return
+			}
+			select {
+			case deliveries <- q.delivery(msg):
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// Get 取出一条消息，队列为空返回 false，用于测试断言
+func (m *Memory) Get(queueName string) (Delivery, bool) {
+	q, err := m.queue(queueName)
+	if err != nil {
+		return Delivery{}, false
+	}
+	msg, ok := q.tryPop()
+	if !ok {
+		return Delivery{}, false
+	}
+	return q.delivery(msg), true
+}
+
+// Len 队列中等待消费的消息数
+func (m *Memory) Len(queueName string) int {
+	q, err := m.queue(queueName)
+	if err != nil {
+		return 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (m *Memory) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+func (m *Memory) isClosed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Memory) GetConsumer(queue string, handler Handler) Consumer {
+	return &MemoryConsumer{
+		memory:  m,
+		queue:   queue,
+		handler: handler,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+type memoryMessage struct {
+	id       string
+	exchange string
+	key      string
+	headers  map[string]interface{}
+	body     []byte
+}
+
+// memoryQueue 无界 FIFO，signal 在有新消息时唤醒一个等待的消费者
+type memoryQueue struct {
+	mu     sync.Mutex
+	items  []*memoryMessage
+	signal chan struct{}
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{signal: make(chan struct{}, 1)}
+}
+
+func (q *memoryQueue) push(msg *memoryMessage) {
+	q.mu.Lock()
+	q.items = append(q.items, msg)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// requeue Nack 后放回队首
+func (q *memoryQueue) requeue(msg *memoryMessage) {
+	q.mu.Lock()
+	q.items = append([]*memoryMessage{msg}, q.items...)
+	q.mu.Unlock()
+	q.notify()
+}
+
+func (q *memoryQueue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *memoryQueue) tryPop() (*memoryMessage, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	msg := q.items[0]
+	q.items = q.items[1:]
+	if len(q.items) > 0 {
+		q.notify()
+	}
+	return msg, true
+}
+
+// pop 阻塞到有消息或 stop 关闭
+func (q *memoryQueue) pop(stop <-chan struct{}) (*memoryMessage, bool) {
+	for {
+		select {
+		case <-stop:
+			return nil, false
+		default:
+		}
+		if msg, ok := q.tryPop(); ok {
+			return msg, true
+		}
+		select {
+		case <-q.signal:
+		case <-stop:
+			return nil, false
+		}
+	}
+}
+
+func (q *memoryQueue) delivery(msg *memoryMessage) Delivery {
+	return Delivery{
+		MessageId:  msg.id,
+		Exchange:   msg.exchange,
+		RoutingKey: msg.key,
+		Headers:    msg.headers,
+		Body:       msg.body,
+		nack: func(requeue bool) error {
+			if requeue {
+				q.requeue(msg)
+			}
+			return nil
+		},
+	}
+}
+
+// MemoryConsumer 内存队列的消费者，单协程处理，Handler 返回错误只记录日志
+type MemoryConsumer struct {
+	memory  *Memory
+	queue   string
+	handler Handler
+
+	mu       sync.Mutex
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var _ Consumer = &MemoryConsumer{}
+
+func (c *MemoryConsumer) Start(ctx context.Context) error {
+	q, err := c.memory.queue(c.queue)
+	if err != nil {
+		return fmt.Errorf("failed to register a consumer: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return errors.New("queue: consumer already started")
+	}
+	c.started = true
+
+	// stop 在 ctx 取消、Shutdown 或 Memory.Close 时关闭
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.memory.done:
+		case <-c.stop:
+			return
+		}
+		c.stopOnce.Do(func() { close(c.stop) })
+	}()
+
+	go func() {
+		defer close(c.done)
+		for {
+			msg, ok := q.pop(c.stop)
+			if !ok {
+				return
+			}
+			message := &Message{
+				Topic: c.queue,
+				Key:   msg.key,
+				Data:  string(msg.body),
+				Id:    msg.id,
+			}
+			if err := c.handler(ctx, message); err != nil {
+				logger.Error("error", logger.String("title", "consumer error"), logger.String("topic", c.queue), logger.String("key", msg.key), logger.String("data", string(msg.body)), logger.Err(err))
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *MemoryConsumer) Stop() {
+	c.Shutdown(context.Background())
+}
+
+// Shutdown 停止取消息并等待当前消息处理完成，未 Start 时直接返回
+func (c *MemoryConsumer) Shutdown(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
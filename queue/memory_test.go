@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"context"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+// newNotifyMemory 只声明队列和绑定，NotifyExchange 由 NewMemory 预置
+func newNotifyMemory(t *testing.T, queues ...string) *Memory {
+	t.Helper()
+	m := NewMemory()
+	t.Cleanup(m.Close)
+
+	topology := &config.QueueTopology{}
+	for _, name := range queues {
+		topology.Queues = append(topology.Queues, config.QueueSpec{Name: name})
+		topology.Bindings = append(topology.Bindings, config.BindingSpec{Queue: name, Exchange: NotifyExchange})
+	}
+	if err := m.Declare(topology); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMessageServiceNotifyFanout(t *testing.T) {
+	m := newNotifyMemory(t, "gateway-1", "gateway-2")
+	svc := NewMessageService(m)
+
+	if err := svc.UpdateBagInfo(10001, 3, "vip"); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.RefreshCoin(10001, 500); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, queue := range []string{"gateway-1", "gateway-2"} {
+		if n := m.Len(queue); n != 2 {
+			t.Fatalf("%s: len = %d, want 2", queue, n)
+		}
+
+		d, _ := m.Get(queue)
+		if d.Exchange != NotifyExchange {
+			t.Fatalf("%s: unexpected delivery %+v", queue, d)
+		}
+		bag, err := DecodeNotify[BagInfoNotify](DefaultNotifyRegistry, string(d.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bag.UserIdx != 10001 || bag.Goodstype != 3 || bag.Param != "vip" {
+			t.Fatalf("%s: unexpected payload %+v", queue, bag)
+		}
+
+		d, _ = m.Get(queue)
+		code, payload, err := DefaultNotifyRegistry.Decode(string(d.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if coin, ok := payload.(*CoinNotify); code != CodeCoin || !ok || coin.Cash != 500 || coin.RechargeInfo != nil {
+			t.Fatalf("%s: unexpected payload %d %+v", queue, code, payload)
+		}
+	}
+}
+
+// RechargeSuccess 沿用 101，消费方按 RechargeInfo 是否为空区分充值
+func TestRechargeSuccessCode(t *testing.T) {
+	m := newNotifyMemory(t, "gateway")
+	if err := NewMessageService(m).RechargeSuccess(10001, 800, 9.99, 1, 7, 0, "USD", true, "o-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, _ := m.Get("gateway")
+	coin, err := DecodeNotify[CoinNotify](DefaultNotifyRegistry, string(d.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if coin.Cash != 800 || coin.RechargeInfo == nil || coin.OrderId != "o-1" || !coin.IsFirstPay {
+		t.Fatalf("unexpected payload %+v", coin)
+	}
+	if _, err := DecodeNotify[GralcashNotify](DefaultNotifyRegistry, string(d.Body)); err == nil {
+		t.Fatal("coin notify should not decode as GralcashNotify")
+	}
+}
+
+func TestRegisterNotifyDuplicate(t *testing.T) {
+	r := NewNotifyRegistry()
+	if err := RegisterNotify[CoinNotify](r, CodeCoin, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterNotify[GralcashNotify](r, CodeCoin, true); err == nil {
+		t.Fatal("duplicate code should be rejected")
+	}
+	if err := RegisterNotify[CoinNotify](r, CodeGralcash, true); err == nil {
+		t.Fatal("duplicate type should be rejected")
+	}
+}
+
+func TestMemoryConfirmUnroutable(t *testing.T) {
+	m := newNotifyMemory(t)
+	err := NewMessageService(m).RefreshCoin(1, 1)
+	if _, ok := err.(*ReturnError); !ok {
+		t.Fatalf("confirmed notify without bound queue should be returned, got %v", err)
+	}
+	if err := NewMessageService(m).UpdateBagInfo(1, 2, ""); err != nil {
+		t.Fatalf("unconfirmed publish should not fail, got %v", err)
+	}
+}
+
+func TestMemoryDelayedDelivery(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	err := m.Declare(&config.QueueTopology{
+		Queues:   []config.QueueSpec{{Name: "timeout"}},
+		Bindings: []config.BindingSpec{{Queue: "timeout", Exchange: DelayExchange, RoutingKey: "order.timeout"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := NewMessageService(m).PushDelayMsg("order.timeout", 50*time.Millisecond, "o-1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.Len("timeout"); n != 0 {
+		t.Fatalf("delayed message delivered early, len = %d", n)
+	}
+
+	deliveries, err := m.ConsumeMessages("timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case d := <-deliveries:
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Fatalf("delivered after %s", elapsed)
+		}
+		if string(d.Body) != "o-1" || d.RoutingKey != "order.timeout" || d.Headers["x-delay"] != 50 {
+			t.Fatalf("unexpected delivery %+v", d)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("delayed message not delivered")
+	}
+}
+
+func TestMemoryConsumer(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	if err := m.Declare(&config.QueueTopology{
+		Exchanges: []config.ExchangeSpec{{Name: "events", Kind: amqp.ExchangeTopic}},
+		Queues:    []config.QueueSpec{{Name: "orders"}},
+		Bindings:  []config.BindingSpec{{Queue: "orders", Exchange: "events", RoutingKey: "order.#"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan *Message, 2)
+	consumer := m.GetConsumer("orders", func(ctx context.Context, msg *Message) error {
+		received <- msg
+		return nil
+	})
+	if err := consumer.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Stop()
+
+	if err := m.PublishMessageByExchange("events", "order.paid", "o-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PublishMessageByExchange("events", "user.login", "u-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.Topic != "orders" || msg.Key != "order.paid" || msg.Data != "o-1" {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("message not consumed")
+	}
+	select {
+	case msg := <-received:
+		t.Fatalf("unbound routing key consumed: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,322 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Middleware 包装 Handler，用于恢复、日志、指标、超时、去重等横切逻辑
+type Middleware func(Handler) Handler
+
+// Chain 按顺序包装，第一个 middleware 在最外层
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Recovery 把 Handler 中的 panic 转为错误并记录堆栈
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					err = panicError(msg, p)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
+
+func panicError(msg *Message, p interface{}) error {
+	logger.Error("queue handler panic", logger.String("topic", msg.Topic), logger.String("key", msg.Key), logger.Any("panic", p), logger.String("stack", string(debug.Stack())))
+	return fmt.Errorf("queue: handler panic: %v", p)
+}
+
+// Logging 记录每条消息的处理耗时，失败为 Error，成功为 Debug
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			if err != nil {
+				logger.Error("consumer", logger.String("topic", msg.Topic), logger.String("key", msg.Key), logger.String("id", msg.Id), logger.Duration("elapsed", time.Since(start)), logger.String("data", msg.Data), logger.Err(err))
+			} else {
+				logger.Debug("consumer", logger.String("topic", msg.Topic), logger.String("key", msg.Key), logger.String("id", msg.Id), logger.Duration("elapsed", time.Since(start)))
+			}
+			return err
+		}
+	}
+}
+
+// Timeout 单条消息的处理时限，超时返回 context.DeadlineExceeded；
+// Handler 未响应 ctx 时仍在后台运行，重试可能与其并发，Handler 应检查 ctx
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				// 在后台协程中 panic 无法被外层 Recovery 捕获，这里转为错误
+				defer func() {
+					if p := recover(); p != nil {
+						done <- panicError(msg, p)
+					}
+				}()
+				done <- next(ctx, msg)
+			}()
+
+			select {
+			case err := <-done:
+				return err
+			case <-ctx.Done():
+				return fmt.Errorf("queue: handler timeout after %s: %w", timeout, ctx.Err())
+			}
+		}
+	}
+}
+
+// DedupConfig 基于 Redis 的消息去重配置
+type DedupConfig struct {
+	// Client 为空时使用 redis.GetClient()
+	Client *goredis.Client
+	// Prefix key 前缀，默认 queue:dedup:
+	Prefix string
+	// Lease 处理中占位的有效期，应大于 Handler 最长耗时，进程崩溃后到期即可重新处理，默认 5m
+	Lease time.Duration
+	// TTL 处理成功后记录保留时间，默认 24h
+	TTL time.Duration
+	// KeyFunc 去重 ID，默认 Message.Id，返回空时不去重
+	KeyFunc func(msg *Message) string
+}
+
+// ErrDedupInFlight 同一消息正在被处理（或处理中的进程已崩溃、占位尚未到期），应稍后重试
+var ErrDedupInFlight = errors.New("queue: dedup: message is being processed")
+
+const dedupDone = "done"
+
+// dedupRelease 占位仍属于自己时删除
+var dedupRelease = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// dedupMarkDone 占位仍属于自己时改为已完成
+var dedupMarkDone = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// Dedup 按队列和消息 ID 去重：处理前以 Lease 为有效期 SETNX 本次处理独有的 token 占位，处理成功后改为已完成并保留 TTL；
+// 只有已完成的消息被跳过，占位仍在处理中时返回 ErrDedupInFlight，处理失败删除占位以便重试。
+// 释放和标记完成都会比对 token，占位过期后被其他消费者取得时不会误删或覆盖对方的占位。
+// Redis 不可用时返回错误而不是冒险重复处理，需配合手动 ack（RetryPolicy）使用，否则返回错误的消息不会重投。
+// Timeout 放在 Dedup 外层时，超时会释放占位而 Handler 可能仍在后台运行，重投的消息会与其并发处理，
+// 应把 Timeout 放在 Dedup 内层，或保证 Handler 响应 ctx 取消
+func Dedup(cfg *DedupConfig) Middleware {
+	var c DedupConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Prefix == "" {
+		c.Prefix = "queue:dedup:"
+	}
+	if c.Lease <= 0 {
+		c.Lease = 5 * time.Minute
+	}
+	if c.TTL <= 0 {
+		c.TTL = 24 * time.Hour
+	}
+	if c.KeyFunc == nil {
+		c.KeyFunc = func(msg *Message) string { return msg.Id }
+	}
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			id := c.KeyFunc(msg)
+			if id == "" {
+				return next(ctx, msg)
+			}
+			client := c.Client
+			if client == nil {
+				client = redis.GetClient()
+			}
+			if client == nil {
+				return errors.New("queue: redis is not initialized")
+			}
+
+			key := c.Prefix + msg.Topic + ":" + id
+			token := uuid.New().String()
+			ok, err := client.SetNX(ctx, key, token, c.Lease).Result()
+			if err != nil {
+				return fmt.Errorf("queue: dedup: %w", err)
+			}
+			if !ok {
+				state, err := client.Get(ctx, key).Result()
+				if err != nil && !errors.Is(err, goredis.Nil) {
+					return fmt.Errorf("queue: dedup: %w", err)
+				}
+				if state == dedupDone {
+					logger.Warn("consumer", logger.String("text", "duplicate message skipped"), logger.String("topic", msg.Topic), logger.String("id", id))
+					return nil
+				}
+				// 占位刚好过期或被删除时同样稍后重试，避免与释放占位的处理并发
+				return ErrDedupInFlight
+			}
+
+			if err := next(ctx, msg); err != nil {
+				if delErr := dedupRelease.Run(context.Background(), client, []string{key}, token).Err(); delErr != nil {
+					logger.Error("consumer", logger.String("text", "dedup release failed"), logger.String("topic", msg.Topic), logger.String("id", id), logger.Err(delErr))
+				}
+				return err
+			}
+
+			// 已处理成功，标记失败只记录日志，返回错误会导致重复处理
+			marked, err := dedupMarkDone.Run(context.Background(), client, []string{key}, token, dedupDone, c.TTL.Milliseconds()).Int()
+			if err != nil {
+				logger.Error("consumer", logger.String("text", "dedup mark done failed"), logger.String("topic", msg.Topic), logger.String("id", id), logger.Err(err))
+			} else if marked == 0 {
+				logger.Warn("consumer", logger.String("text", "dedup lease lost before handler finished"), logger.String("topic", msg.Topic), logger.String("id", id), logger.Duration("lease", c.Lease))
+			}
+			return nil
+		}
+	}
+}
+
+// DefaultHandlerBuckets 处理耗时直方图默认分桶上界
+var DefaultHandlerBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	5 * time.Second,
+	30 * time.Second,
+}
+
+// HandlerMetricSnapshot 某个队列的处理指标快照
+type HandlerMetricSnapshot struct {
+	Topic string
+	Count int64
+	Sum   time.Duration
+	// Buckets 与 HandlerMetrics 分桶一一对应的累计计数，最后一个为 +Inf
+	Buckets []int64
+	Errors  int64
+}
+
+// HandlerMetrics 按队列统计处理耗时和失败数，可定期 Snapshot 导出到监控系统
+type HandlerMetrics struct {
+	buckets []time.Duration
+	mu      sync.Mutex
+	series  map[string]*handlerSeries
+}
+
+type handlerSeries struct {
+	count   int64
+	sum     time.Duration
+	buckets []int64
+	errors  int64
+}
+
+// NewHandlerMetrics 创建指标收集器，buckets 为空使用 DefaultHandlerBuckets
+func NewHandlerMetrics(buckets []time.Duration) *HandlerMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultHandlerBuckets
+	}
+	sorted := make([]time.Duration, len(buckets))
+	copy(sorted, buckets)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return &HandlerMetrics{
+		buckets: sorted,
+		series:  make(map[string]*handlerSeries),
+	}
+}
+
+// Buckets 分桶上界
+func (m *HandlerMetrics) Buckets() []time.Duration {
+	return m.buckets
+}
+
+// Middleware 记录每条消息的处理耗时
+func (m *HandlerMetrics) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			start := time.Now()
+			err := next(ctx, msg)
+			m.observe(msg.Topic, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+func (m *HandlerMetrics) observe(topic string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.series[topic]
+	if !ok {
+		s = &handlerSeries{buckets: make([]int64, len(m.buckets)+1)}
+		m.series[topic] = s
+	}
+
+	s.count++
+	s.sum += elapsed
+	i := sort.Search(len(m.buckets), func(i int) bool { return elapsed <= m.buckets[i] })
+	s.buckets[i]++
+	if err != nil {
+		s.errors++
+	}
+}
+
+// Snapshot 当前所有队列的指标，Buckets 为累计值
+func (m *HandlerMetrics) Snapshot() []HandlerMetricSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshots := make([]HandlerMetricSnapshot, 0, len(m.series))
+	for topic, s := range m.series {
+		snapshot := HandlerMetricSnapshot{
+			Topic:   topic,
+			Count:   s.count,
+			Sum:     s.sum,
+			Buckets: make([]int64, len(s.buckets)),
+			Errors:  s.errors,
+		}
+		var cumulative int64
+		for i, n := range s.buckets {
+			cumulative += n
+			snapshot.Buckets[i] = cumulative
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Topic < snapshots[j].Topic })
+	return snapshots
+}
+
+// Reset 清空已收集的指标
+func (m *HandlerMetrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.series = make(map[string]*handlerSeries)
+}
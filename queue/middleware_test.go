@@ -0,0 +1,122 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	const key = "queue:dedup:orders:m-1"
+	msg := &Message{Topic: "orders", Id: "m-1"}
+	ctx := context.Background()
+
+	calls := 0
+	var handleErr error
+	handler := Dedup(&DedupConfig{Client: client, Lease: time.Minute, TTL: time.Hour})(func(ctx context.Context, msg *Message) error {
+		calls++
+		// 处理中只占位 Lease，重复投递应稍后重试而不是被跳过
+		if state, _ := server.Get(key); state == "" || state == dedupDone {
+			t.Errorf("state during handling = %q", state)
+		}
+		if ttl := server.TTL(key); ttl != time.Minute {
+			t.Errorf("lease ttl = %v", ttl)
+		}
+		return handleErr
+	})
+
+	handleErr = errors.New("db down")
+	if err := handler(ctx, msg); err != handleErr {
+		t.Fatalf("err = %v", err)
+	}
+	if server.Exists(key) {
+		t.Fatal("failed message should release its lease")
+	}
+
+	handleErr = nil
+	if err := handler(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := server.Get(key); state != dedupDone || server.TTL(key) != time.Hour {
+		t.Fatalf("state after success = %q ttl %v", state, server.TTL(key))
+	}
+
+	if err := handler(ctx, msg); err != nil || calls != 2 {
+		t.Fatalf("duplicate should be skipped, err = %v calls = %d", err, calls)
+	}
+}
+
+func TestDedupLeaseAfterCrash(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	const key = "queue:dedup:orders:m-1"
+	msg := &Message{Topic: "orders", Id: "m-1"}
+	ctx := context.Background()
+
+	// 模拟进程在处理中崩溃留下的占位
+	server.Set(key, "crashed-worker")
+	server.SetTTL(key, 5*time.Minute)
+
+	calls := 0
+	handler := Dedup(&DedupConfig{Client: client})(func(ctx context.Context, msg *Message) error {
+		calls++
+		return nil
+	})
+
+	if err := handler(ctx, msg); !errors.Is(err, ErrDedupInFlight) || calls != 0 {
+		t.Fatalf("in-flight message: err = %v calls = %d", err, calls)
+	}
+
+	server.FastForward(5 * time.Minute)
+	if err := handler(ctx, msg); err != nil || calls != 1 {
+		t.Fatalf("expired lease should be processed again, err = %v calls = %d", err, calls)
+	}
+	if state, _ := server.Get(key); state != dedupDone {
+		t.Fatalf("state = %q", state)
+	}
+}
+
+func TestDedupDoesNotTouchOtherWorkersLease(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	const key = "queue:dedup:orders:m-1"
+	msg := &Message{Topic: "orders", Id: "m-1"}
+	ctx := context.Background()
+
+	// Handler 超过 Lease 仍未返回，期间占位过期并被另一个消费者取得
+	var handleErr error
+	handler := Dedup(&DedupConfig{Client: client, Lease: time.Minute})(func(ctx context.Context, msg *Message) error {
+		server.FastForward(time.Minute)
+		server.Set(key, "other-worker")
+		server.SetTTL(key, time.Minute)
+		return handleErr
+	})
+
+	handleErr = errors.New("db down")
+	if err := handler(ctx, msg); err != handleErr {
+		t.Fatalf("err = %v", err)
+	}
+	if state, _ := server.Get(key); state != "other-worker" {
+		t.Fatalf("failed handler released another worker's lease, state = %q", state)
+	}
+
+	server.Del(key)
+	handleErr = nil
+	if err := handler(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := server.Get(key); state != "other-worker" || server.TTL(key) != time.Minute {
+		t.Fatalf("handler overwrote another worker's lease, state = %q ttl %v", state, server.TTL(key))
+	}
+}
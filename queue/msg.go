@@ -1,7 +1,6 @@
 package queue
 
 import (
-	"github.com/kmcqqq/pkg/utils"
 	"time"
 )
 
@@ -11,12 +10,26 @@ type NotifyMsg struct {
 }
 
 type MessageService struct {
-	queue Queue // 通过接口注入队列
+	queue    Publisher // 通过接口注入队列
+	registry *NotifyRegistry
 }
 
-// 创建 MessageService 实例，注入队列实例
-func NewMessageService(q Queue) *MessageService {
-	return &MessageService{queue: q}
+// 创建 MessageService 实例，注入队列实例；在事务中写入 outbox 时传入 outbox.NewWriter(tx)
+func NewMessageService(q Publisher) *MessageService {
+	return NewMessageServiceWithRegistry(q, DefaultNotifyRegistry)
+}
+
+// NewMessageServiceWithRegistry 使用自定义通知注册表，Publish 按其中的类型查找 code
+func NewMessageServiceWithRegistry(q Publisher, registry *NotifyRegistry) *MessageService {
+	return &MessageService{queue: q, registry: registry}
+}
+
+// publishConfirm 队列实现 ConfirmPublisher 时等待 broker 确认，否则普通发布
+func (r *MessageService) publishConfirm(exchangeName, routingKey, message string) error {
+	if publisher, ok := r.queue.(ConfirmPublisher); ok {
+		return publisher.PublishMessageConfirm(exchangeName, routingKey, message)
+	}
+	return r.queue.PublishMessageByExchange(exchangeName, routingKey, message)
 }
 
 // Direct 模式
@@ -31,63 +44,22 @@ func (r *MessageService) PushDelayMsg(routingKey string, delayTime time.Duration
 	return err
 }
 
-// RefreshCoin 币更新
+// RefreshCoin 币更新，涉及金额，等待 broker 确认
 func (r *MessageService) RefreshCoin(userIdx int64, cash int64) error {
-	message := NotifyMsg{
-		Code: 101,
-		Data: struct {
-			UserIdx int64 `json:"useridx"`
-			Cash    int64 `json:"cash"`
-		}{
-			UserIdx: userIdx,
-			Cash:    cash,
-		},
-	}
-	messageStr, err := utils.Struct2Json(message)
-	if err != nil {
-		return err
-	}
-	err = r.queue.PublishMessageByExchange("notify", "", messageStr)
-	return err
+	return Publish(r, CoinNotify{UserIdx: userIdx, Cash: cash})
 }
 
-// RefreshGralcash 果子更新通知
+// RefreshGralcash 果子更新通知，涉及金额，等待 broker 确认
 func (r *MessageService) RefreshGralcash(userIdx int64, gralcash float64) error {
-	message := NotifyMsg{
-		Code: 112,
-		Data: struct {
-			UserIdx int64   `json:"useridx"`
-			Cash    float64 `json:"cash"`
-		}{
-			UserIdx: userIdx,
-			Cash:    gralcash,
-		},
-	}
-	messageStr, err := utils.Struct2Json(message)
-	if err != nil {
-		return err
-	}
-	err = r.queue.PublishMessageByExchange("notify", "", messageStr)
-	return err
+	return Publish(r, GralcashNotify{UserIdx: userIdx, Cash: gralcash})
 }
 
-// RechargeSuccess 充值成功
+// RechargeSuccess 充值成功，与 RefreshCoin 同为 101，附带充值字段；涉及金额，等待 broker 确认
 func (r *MessageService) RechargeSuccess(userIdx int64, cash int64, money float64, dtype int, productId int, couponId int, currency string, isFirstPay bool, orderId string) error {
-	message := NotifyMsg{
-		Code: 101,
-		Data: struct {
-			UserIdx    int64   `json:"useridx"`
-			Cash       int64   `json:"cash"`
-			Money      float64 `json:"money"`
-			Dtype      int     `json:"dtype"`
-			ProductId  int     `json:"productId"`
-			CouponId   int     `json:"couponId"`
-			Currency   string  `json:"currency"`
-			IsFirstPay bool    `json:"isFirstPay"`
-			OrderId    string  `json:"orderId"`
-		}{
-			UserIdx:    userIdx,
-			Cash:       cash,
+	return Publish(r, CoinNotify{
+		UserIdx: userIdx,
+		Cash:    cash,
+		RechargeInfo: &RechargeInfo{
 			Money:      money,
 			Dtype:      dtype,
 			ProductId:  productId,
@@ -96,86 +68,27 @@ func (r *MessageService) RechargeSuccess(userIdx int64, cash int64, money float6
 			IsFirstPay: isFirstPay,
 			OrderId:    orderId,
 		},
-	}
-	messageStr, err := utils.Struct2Json(message)
-	if err != nil {
-		return err
-	}
-	err = r.queue.PublishMessageByExchange("notify", "", messageStr)
-	return err
+	})
 }
 
 // GameWinFloating 游戏中奖飘条
 func (r *MessageService) GameWinFloating(userIdx int64, cash int64, roomId int64, gameId, ntype int, gameIcon string) error {
-	message := NotifyMsg{
-		Code: 161,
-		Data: struct {
-			UserIdx  int64  `json:"useridx"`
-			Cash     int64  `json:"cash"`
-			RoomId   int64  `json:"roomid"`
-			GameId   int    `json:"gameId"`
-			Type     int    `json:"type"`
-			GameIcon string `json:"gameIcon"`
-		}{
-			UserIdx:  userIdx,
-			Cash:     cash,
-			RoomId:   roomId,
-			GameId:   gameId,
-			Type:     ntype,
-			GameIcon: gameIcon,
-		},
-	}
-	messageStr, err := utils.Struct2Json(message)
-	if err != nil {
-		return err
-	}
-	err = r.queue.PublishMessageByExchange("notify", "", messageStr)
-	return err
+	return Publish(r, GameWinNotify{
+		UserIdx:  userIdx,
+		Cash:     cash,
+		RoomId:   roomId,
+		GameId:   gameId,
+		Type:     ntype,
+		GameIcon: gameIcon,
+	})
 }
 
 // UpdateBagInfo 更新背包道具 goodsType 2 坐骑 3vip 4 头像框 18
 func (r *MessageService) UpdateBagInfo(userIdx int64, goodsType int, param string) error {
-	message := NotifyMsg{
-		Code: 121,
-		Data: struct {
-			UserIdx   int64  `json:"useridx"`
-			Goodstype int    `json:"goodstype"`
-			Param     string `json:"param"`
-		}{
-			UserIdx:   userIdx,
-			Goodstype: goodsType,
-			Param:     param,
-		},
-	}
-
-	messageStr, err := utils.Struct2Json(message)
-	if err != nil {
-		return err
-	}
-
-	err = r.queue.PublishMessageByExchange("notify", "", messageStr)
-	return err
+	return Publish(r, BagInfoNotify{UserIdx: userIdx, Goodstype: goodsType, Param: param})
 }
 
 // SendVipPiaoTiao 开通 VIP 票条
 func (r *MessageService) SendVipPiaoTiao(userIdx int64, level int, content string) error {
-	message := NotifyMsg{
-		Code: 129,
-		Data: struct {
-			UserIdx int64  `json:"useridx"`
-			Level   int    `json:"level"`
-			Content string `json:"content"`
-		}{
-			UserIdx: userIdx,
-			Level:   level,
-			Content: content,
-		}}
-
-	messageStr, err := utils.Struct2Json(message)
-	if err != nil {
-		return err
-	}
-
-	err = r.queue.PublishMessageByExchange("notify", "", messageStr)
-	return err
+	return Publish(r, VipPiaoTiaoNotify{UserIdx: userIdx, Level: level, Content: content})
 }
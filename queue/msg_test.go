@@ -0,0 +1,61 @@
+package queue
+
+import (
+	"github.com/kmcqqq/pkg/config"
+	"testing"
+	"time"
+)
+
+// recordQueue 只实现 Queue，记录普通发布
+type recordQueue struct {
+	published []string
+}
+
+func (q *recordQueue) Init(*config.ServerInfo) error { return nil }
+
+func (q *recordQueue) PublishMessageByExchange(exchangeName, routingKey, message string) error {
+	q.published = append(q.published, exchangeName)
+	return nil
+}
+
+func (q *recordQueue) PublishDelayMessage(string, string, time.Duration) error { return nil }
+
+func (q *recordQueue) ConsumeMessages(string) (<-chan Delivery, error) { return nil, nil }
+
+func (q *recordQueue) Close() {}
+
+func (q *recordQueue) GetConsumer(string, Handler) Consumer { return nil }
+
+// confirmQueue 额外实现 ConfirmPublisher
+type confirmQueue struct {
+	recordQueue
+	confirmed []string
+}
+
+func (q *confirmQueue) PublishMessageConfirm(exchangeName, routingKey, message string) error {
+	q.confirmed = append(q.confirmed, exchangeName)
+	return nil
+}
+
+func (q *confirmQueue) PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error {
+	q.confirmed = append(q.confirmed, DelayExchange)
+	return nil
+}
+
+func TestRechargeSuccessConfirm(t *testing.T) {
+	plain := &recordQueue{}
+	if err := NewMessageService(plain).RechargeSuccess(1, 100, 0.99, 1, 1, 0, "USD", true, "o-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(plain.published) != 1 || plain.published[0] != "notify" {
+		t.Fatalf("queue without confirm: published = %v", plain.published)
+	}
+
+	confirm := &confirmQueue{}
+	if err := NewMessageService(confirm).RechargeSuccess(1, 100, 0.99, 1, 1, 0, "USD", true, "o-1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(confirm.confirmed) != 1 || len(confirm.published) != 0 {
+		t.Fatalf("queue with confirm: confirmed = %v, published = %v", confirm.confirmed, confirm.published)
+	}
+}
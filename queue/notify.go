@@ -0,0 +1,187 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kmcqqq/pkg/utils"
+	"reflect"
+	"sync"
+)
+
+const (
+	CodeCoin        = 101
+	CodeGralcash    = 112
+	CodeBagInfo     = 121
+	CodeVipPiaoTiao = 129
+	CodeGameWin     = 161
+)
+
+// CoinNotify 币更新（101），充值成功时附带 RechargeInfo，否则不输出充值字段
+type CoinNotify struct {
+	UserIdx int64 `json:"useridx"`
+	Cash    int64 `json:"cash"`
+	*RechargeInfo
+}
+
+// RechargeInfo 充值成功的附加字段
+type RechargeInfo struct {
+	Money      float64 `json:"money"`
+	Dtype      int     `json:"dtype"`
+	ProductId  int     `json:"productId"`
+	CouponId   int     `json:"couponId"`
+	Currency   string  `json:"currency"`
+	IsFirstPay bool    `json:"isFirstPay"`
+	OrderId    string  `json:"orderId"`
+}
+
+// GralcashNotify 果子更新（112）
+type GralcashNotify struct {
+	UserIdx int64   `json:"useridx"`
+	Cash    float64 `json:"cash"`
+}
+
+// BagInfoNotify 背包道具更新（121），Goodstype 2 坐骑 3 vip 4 头像框 18
+type BagInfoNotify struct {
+	UserIdx   int64  `json:"useridx"`
+	Goodstype int    `json:"goodstype"`
+	Param     string `json:"param"`
+}
+
+// VipPiaoTiaoNotify 开通 VIP 票条（129）
+type VipPiaoTiaoNotify struct {
+	UserIdx int64  `json:"useridx"`
+	Level   int    `json:"level"`
+	Content string `json:"content"`
+}
+
+// GameWinNotify 游戏中奖飘条（161）
+type GameWinNotify struct {
+	UserIdx  int64  `json:"useridx"`
+	Cash     int64  `json:"cash"`
+	RoomId   int64  `json:"roomid"`
+	GameId   int    `json:"gameId"`
+	Type     int    `json:"type"`
+	GameIcon string `json:"gameIcon"`
+}
+
+// DefaultNotifyRegistry 内置通知类型的注册表，MessageService 默认使用
+var DefaultNotifyRegistry = NewNotifyRegistry()
+
+func init() {
+	mustRegisterNotify[CoinNotify](DefaultNotifyRegistry, CodeCoin, true)
+	mustRegisterNotify[GralcashNotify](DefaultNotifyRegistry, CodeGralcash, true)
+	mustRegisterNotify[BagInfoNotify](DefaultNotifyRegistry, CodeBagInfo, false)
+	mustRegisterNotify[VipPiaoTiaoNotify](DefaultNotifyRegistry, CodeVipPiaoTiao, false)
+	mustRegisterNotify[GameWinNotify](DefaultNotifyRegistry, CodeGameWin, false)
+}
+
+type notifyEntry struct {
+	code    int
+	typ     reflect.Type
+	confirm bool
+}
+
+// NotifyRegistry 通知 code 与 payload 类型的一一映射
+type NotifyRegistry struct {
+	mu     sync.RWMutex
+	byCode map[int]*notifyEntry
+	byType map[reflect.Type]*notifyEntry
+}
+
+func NewNotifyRegistry() *NotifyRegistry {
+	return &NotifyRegistry{
+		byCode: make(map[int]*notifyEntry),
+		byType: make(map[reflect.Type]*notifyEntry),
+	}
+}
+
+// RegisterNotify 注册 payload 类型，code 或类型已注册时返回错误；confirm 为 true 时发布等待 broker 确认，用于涉及金额的通知
+func RegisterNotify[T any](r *NotifyRegistry, code int, confirm bool) error {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.byCode[code]; ok {
+		return fmt.Errorf("queue: notify code %d already registered by %s", code, e.typ)
+	}
+	if e, ok := r.byType[typ]; ok {
+		return fmt.Errorf("queue: notify type %s already registered with code %d", typ, e.code)
+	}
+
+	e := &notifyEntry{code: code, typ: typ, confirm: confirm}
+	r.byCode[code] = e
+	r.byType[typ] = e
+	return nil
+}
+
+func mustRegisterNotify[T any](r *NotifyRegistry, code int, confirm bool) {
+	if err := RegisterNotify[T](r, code, confirm); err != nil {
+		panic(err)
+	}
+}
+
+func (r *NotifyRegistry) lookupType(typ reflect.Type) (*notifyEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.byType[typ]
+	if !ok {
+		return nil, fmt.Errorf("queue: notify type %s is not registered", typ)
+	}
+	return e, nil
+}
+
+// Decode 把通知消息解析为注册的 payload 类型，返回 code 和指向 payload 的指针
+func (r *NotifyRegistry) Decode(data string) (int, interface{}, error) {
+	var raw struct {
+		Code int             `json:"code"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := utils.Json2Struct(data, &raw); err != nil {
+		return 0, nil, err
+	}
+
+	r.mu.RLock()
+	e, ok := r.byCode[raw.Code]
+	r.mu.RUnlock()
+	if !ok {
+		return raw.Code, nil, fmt.Errorf("queue: notify code %d is not registered", raw.Code)
+	}
+
+	payload := reflect.New(e.typ).Interface()
+	if err := json.Unmarshal(raw.Data, payload); err != nil {
+		return raw.Code, nil, fmt.Errorf("queue: decode notify %d: %w", raw.Code, err)
+	}
+	return raw.Code, payload, nil
+}
+
+// DecodeNotify 解析通知消息为 T，消息 code 与 T 注册的 code 不一致时返回错误
+func DecodeNotify[T any](r *NotifyRegistry, data string) (*T, error) {
+	code, payload, err := r.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := payload.(*T)
+	if !ok {
+		return nil, fmt.Errorf("queue: notify code %d is %T, not %T", code, payload, v)
+	}
+	return v, nil
+}
+
+// Publish 按 payload 类型查找 code 并推送到 NotifyExchange
+func Publish[T any](s *MessageService, payload T) error {
+	e, err := s.registry.lookupType(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return err
+	}
+
+	messageStr, err := utils.Struct2Json(NotifyMsg{Code: e.code, Data: payload})
+	if err != nil {
+		return err
+	}
+	if e.confirm {
+		return s.publishConfirm(NotifyExchange, "", messageStr)
+	}
+	return s.queue.PublishMessageByExchange(NotifyExchange, "", messageStr)
+}
@@ -0,0 +1,87 @@
+package outbox
+
+import (
+	"github.com/kmcqqq/pkg/queue"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	StatusPending = 0
+	StatusSent    = 1
+	// StatusFailed 超过最大重试次数，不再投递，需人工处理后改回 StatusPending
+	StatusFailed = 2
+)
+
+// Message outbox 表记录，与业务数据在同一事务中写入，由 Relay 按 Id 顺序投递
+type Message struct {
+	Id         int64  `gorm:"primaryKey;autoIncrement"`
+	Exchange   string `gorm:"size:255"`
+	RoutingKey string `gorm:"size:255"`
+	Body       string
+	// Delay 延迟毫秒数，大于 0 时经 PublishDelayMessageConfirm 投递，从 CreatedAt 起算
+	Delay         int64
+	Status        int       `gorm:"index:idx_queue_outbox_status,priority:1"`
+	NextAttemptAt time.Time `gorm:"index:idx_queue_outbox_status,priority:2"`
+	Attempts      int
+	LastError     string `gorm:"size:1024"`
+	CreatedAt     time.Time
+	SentAt        *time.Time
+}
+
+func (Message) TableName() string {
+	return "queue_outbox"
+}
+
+// AutoMigrate 创建或更新 outbox 表
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Message{})
+}
+
+// Writer 在调用方事务中写入 outbox，事务回滚时消息一并丢弃，提交后由 Relay 投递：
+//
+//	db.Transaction(func(tx *gorm.DB) error {
+//		// 业务写入 ...
+//		return queue.NewMessageService(outbox.NewWriter(tx)).RechargeSuccess(...)
+//	})
+type Writer struct {
+	tx *gorm.DB
+}
+
+var _ queue.Publisher = &Writer{}
+var _ queue.ConfirmPublisher = &Writer{}
+
+func NewWriter(tx *gorm.DB) *Writer {
+	return &Writer{tx: tx}
+}
+
+func (w *Writer) PublishMessageByExchange(exchangeName, routingKey, message string) error {
+	return w.insert(exchangeName, routingKey, message, 0)
+}
+
+// PublishMessageConfirm 与 PublishMessageByExchange 相同，Relay 投递时等待 broker 确认后才标记为已发送
+func (w *Writer) PublishMessageConfirm(exchangeName, routingKey, message string) error {
+	return w.insert(exchangeName, routingKey, message, 0)
+}
+
+func (w *Writer) PublishDelayMessage(routingKey, message string, delayTime time.Duration) error {
+	return w.insert(queue.DelayExchange, routingKey, message, delayTime)
+}
+
+// PublishDelayMessageConfirm 与 PublishDelayMessage 相同
+func (w *Writer) PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error {
+	return w.insert(queue.DelayExchange, routingKey, message, delayTime)
+}
+
+func (w *Writer) insert(exchange, routingKey, body string, delay time.Duration) error {
+	now := time.Now()
+	return w.tx.Create(&Message{
+		Exchange:      exchange,
+		RoutingKey:    routingKey,
+		Body:          body,
+		Delay:         int64(delay / time.Millisecond),
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}).Error
+}
@@ -0,0 +1,258 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/queue"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRelayInterval   = 1 * time.Second
+	defaultRelayBatchSize  = 100
+	defaultRelayAttempts   = 10
+	defaultRelayRetryDelay = 1 * time.Second
+	defaultRelayMaxDelay   = 5 * time.Minute
+	defaultRetention       = 7 * 24 * time.Hour
+	cleanupInterval        = 1 * time.Hour
+)
+
+// RelayConfig 投递配置，零值使用默认值
+type RelayConfig struct {
+	// Interval 扫描间隔，默认 1s
+	Interval time.Duration
+	// BatchSize 每次扫描的最大条数，默认 100
+	BatchSize int
+	// MaxAttempts 最大投递次数，超过后标记为 StatusFailed，默认 10
+	MaxAttempts int
+	// RetryDelay 首次重试间隔，之后翻倍，最大 5m，默认 1s
+	RetryDelay time.Duration
+	// Retention 已发送记录的保留时间，默认 7 天
+	Retention time.Duration
+}
+
+// Relay 按 Id 顺序投递 outbox 中的待发送消息，某条失败后在它投递成功或标记为 StatusFailed 之前，后续消息都不会投递。
+// 投递等待 broker 确认后才标记已发送，崩溃时可能重复投递（at-least-once）；为保证顺序同一张表只应运行一个 Relay
+type Relay struct {
+	db  *gorm.DB
+	pub queue.ConfirmPublisher
+	cfg RelayConfig
+
+	mu       sync.Mutex
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewRelay pub 需支持确认发布，RabbitMQ、RedisStream 和 Memory 均实现 queue.ConfirmPublisher
+func NewRelay(db *gorm.DB, pub queue.ConfirmPublisher, cfg *RelayConfig) *Relay {
+	r := &Relay{
+		db:   db,
+		pub:  pub,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if cfg != nil {
+		r.cfg = *cfg
+	}
+	if r.cfg.Interval <= 0 {
+		r.cfg.Interval = defaultRelayInterval
+	}
+	if r.cfg.BatchSize <= 0 {
+		r.cfg.BatchSize = defaultRelayBatchSize
+	}
+	if r.cfg.MaxAttempts <= 0 {
+		r.cfg.MaxAttempts = defaultRelayAttempts
+	}
+	if r.cfg.RetryDelay <= 0 {
+		r.cfg.RetryDelay = defaultRelayRetryDelay
+	}
+	if r.cfg.Retention <= 0 {
+		r.cfg.Retention = defaultRetention
+	}
+	return r
+}
+
+// Start 后台定期投递和清理，ctx 取消或 Shutdown 后停止
+func (r *Relay) Start(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return errors.New("outbox: relay already started")
+	}
+	r.started = true
+
+	go r.run(ctx)
+	return nil
+}
+
+func (r *Relay) run(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// 整批都处理完说明可能还有积压，立即继续
+		for {
+			n, err := r.Flush(ctx)
+			if err != nil {
+				logger.Error("outbox", logger.String("text", "relay failed"), logger.Err(err))
+			}
+			if err != nil || n < r.cfg.BatchSize || r.stopping() {
+				break
+			}
+		}
+
+		if time.Since(lastCleanup) >= cleanupInterval {
+			lastCleanup = time.Now()
+			if n, err := r.Cleanup(ctx); err != nil {
+				logger.Error("outbox", logger.String("text", "cleanup failed"), logger.Err(err))
+			} else if n > 0 {
+				logger.Info("outbox", logger.String("text", "cleanup sent messages"), logger.Int64("count", n))
+			}
+		}
+	}
+}
+
+func (r *Relay) stopping() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *Relay) Stop() {
+	r.Shutdown(context.Background())
+}
+
+// Shutdown 等待当前批次投递完成
+func (r *Relay) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+
+	r.mu.Lock()
+	started := r.started
+	r.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush 按 Id 顺序投递待发送消息，遇到未到重试时间或本次投递失败的消息即停止，返回已发送或标记为失败的条数。
+// 查询不按 next_attempt_at 过滤，否则退避中的消息会被跳过，后面的消息先于它投递
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	var msgs []Message
+	err := r.db.WithContext(ctx).
+		Where("status = ?", StatusPending).
+		Order("id").
+		Limit(r.cfg.BatchSize).
+		Find(&msgs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	for i := range msgs {
+		msg := &msgs[i]
+		// 队首消息仍在退避中，后续消息不能越过它
+		if msg.NextAttemptAt.After(now) {
+			return i, nil
+		}
+		if err := r.publish(msg); err != nil {
+			if markErr := r.markFailed(ctx, msg, err); markErr != nil {
+				return i, markErr
+			}
+			// 未达到最大次数时停止，后续消息等这条重试成功后再投递
+			if msg.Status == StatusPending {
+				return i, nil
+			}
+			continue
+		}
+		if err := r.markSent(ctx, msg); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// publish 总是等待 broker 确认，未确认的消息不会被标记为已发送；延迟消息扣除已在 outbox 中等待的时间
+func (r *Relay) publish(msg *Message) error {
+	if msg.Delay > 0 {
+		remaining := time.Until(msg.CreatedAt.Add(time.Duration(msg.Delay) * time.Millisecond))
+		if remaining < 0 {
+			remaining = 0
+		}
+		return r.pub.PublishDelayMessageConfirm(msg.RoutingKey, msg.Body, remaining)
+	}
+	return r.pub.PublishMessageConfirm(msg.Exchange, msg.RoutingKey, msg.Body)
+}
+
+func (r *Relay) markSent(ctx context.Context, msg *Message) error {
+	now := time.Now()
+	return r.db.WithContext(ctx).Model(msg).Updates(map[string]interface{}{
+		"status":   StatusSent,
+		"attempts": msg.Attempts + 1,
+		"sent_at":  now,
+	}).Error
+}
+
+// markFailed 记录失败并按指数退避安排下次投递，超过 MaxAttempts 标记为 StatusFailed
+func (r *Relay) markFailed(ctx context.Context, msg *Message, publishErr error) error {
+	msg.Attempts++
+	lastError := publishErr.Error()
+	if len(lastError) > 1024 {
+		lastError = lastError[:1024]
+	}
+
+	delay := r.cfg.RetryDelay
+	for i := 1; i < msg.Attempts && delay < defaultRelayMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > defaultRelayMaxDelay {
+		delay = defaultRelayMaxDelay
+	}
+
+	if msg.Attempts >= r.cfg.MaxAttempts {
+		msg.Status = StatusFailed
+		logger.Error("outbox", logger.String("text", "message failed"), logger.Int64("id", msg.Id), logger.String("exchange", msg.Exchange), logger.String("key", msg.RoutingKey), logger.String("body", msg.Body), logger.Err(publishErr))
+	} else {
+		logger.Warn("outbox", logger.String("text", "publish failed, retry later"), logger.Int64("id", msg.Id), logger.Int("attempts", msg.Attempts), logger.Duration("delay", delay), logger.Err(publishErr))
+	}
+
+	return r.db.WithContext(ctx).Model(msg).Updates(map[string]interface{}{
+		"status":          msg.Status,
+		"attempts":        msg.Attempts,
+		"next_attempt_at": time.Now().Add(delay),
+		"last_error":      lastError,
+	}).Error
+}
+
+// Cleanup 删除超过保留时间的已发送记录
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", StatusSent, time.Now().Add(-r.cfg.Retention)).
+		Delete(&Message{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,199 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/queue"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(&config.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+// recordingPublisher 记录投递顺序，fail 中的 body 首次投递失败
+type recordingPublisher struct {
+	fail      map[string]bool
+	published []string
+}
+
+func (p *recordingPublisher) PublishMessageConfirm(exchangeName, routingKey, message string) error {
+	if p.fail[message] {
+		delete(p.fail, message)
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, message)
+	return nil
+}
+
+func (p *recordingPublisher) PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error {
+	return p.PublishMessageConfirm("", routingKey, message)
+}
+
+// bufferingPublisher 不等待确认的发布总是返回 nil（如断线时写入内存缓冲），等待确认的发布失败
+type bufferingPublisher struct {
+	buffered []string
+}
+
+func (p *bufferingPublisher) PublishMessageByExchange(exchangeName, routingKey, message string) error {
+	p.buffered = append(p.buffered, message)
+	return nil
+}
+
+func (p *bufferingPublisher) PublishDelayMessage(routingKey, message string, delayTime time.Duration) error {
+	p.buffered = append(p.buffered, message)
+	return nil
+}
+
+func (p *bufferingPublisher) PublishMessageConfirm(exchangeName, routingKey, message string) error {
+	return queue.ErrNotConnected
+}
+
+func (p *bufferingPublisher) PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error {
+	return queue.ErrNotConnected
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存库每个连接独立，只保留一个连接
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err := AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRelayKeepsOrderWhileHeadIsBackingOff(t *testing.T) {
+	db := openTestDB(t)
+	writer := NewWriter(db)
+	for _, body := range []string{"1", "2", "3"} {
+		if err := writer.PublishMessageConfirm("ex", "key", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pub := &recordingPublisher{fail: map[string]bool{"1": true}}
+	relay := NewRelay(db, pub, &RelayConfig{RetryDelay: time.Hour})
+	ctx := context.Background()
+
+	// 1 投递失败进入退避，本轮停止
+	if n, err := relay.Flush(ctx); err != nil || n != 0 {
+		t.Fatalf("first flush = %d, %v", n, err)
+	}
+	// 1 未到重试时间，2、3 不能越过它先投递
+	if n, err := relay.Flush(ctx); err != nil || n != 0 {
+		t.Fatalf("second flush = %d, %v", n, err)
+	}
+	if len(pub.published) != 0 {
+		t.Fatalf("published %v while head message is backing off", pub.published)
+	}
+
+	if err := db.Model(&Message{}).Where("body = ?", "1").Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := relay.Flush(ctx); err != nil || n != 3 {
+		t.Fatalf("third flush = %d, %v", n, err)
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(pub.published, want) {
+		t.Fatalf("published %v, want %v", pub.published, want)
+	}
+
+	var pending int64
+	db.Model(&Message{}).Where("status = ?", StatusPending).Count(&pending)
+	if pending != 0 {
+		t.Fatalf("%d messages still pending", pending)
+	}
+}
+
+func TestRelaySkipsFailedMessage(t *testing.T) {
+	db := openTestDB(t)
+	writer := NewWriter(db)
+	for _, body := range []string{"1", "2"} {
+		if err := writer.PublishMessageConfirm("ex", "key", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// MaxAttempts 为 1 时首次失败即标记为 StatusFailed，不再阻塞后续消息
+	pub := &recordingPublisher{fail: map[string]bool{"1": true}}
+	relay := NewRelay(db, pub, &RelayConfig{MaxAttempts: 1})
+	if n, err := relay.Flush(context.Background()); err != nil || n != 2 {
+		t.Fatalf("flush = %d, %v", n, err)
+	}
+	if want := []string{"2"}; !reflect.DeepEqual(pub.published, want) {
+		t.Fatalf("published %v, want %v", pub.published, want)
+	}
+
+	var msg Message
+	if err := db.Where("body = ?", "1").First(&msg).Error; err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != StatusFailed || msg.Attempts != 1 {
+		t.Fatalf("message 1 status = %d attempts = %d", msg.Status, msg.Attempts)
+	}
+}
+
+func TestRelayWaitsForConfirmOnDelayedMessage(t *testing.T) {
+	db := openTestDB(t)
+	writer := NewWriter(db)
+	if err := writer.PublishMessageConfirm("ex", "key", "now"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.PublishDelayMessage("order.timeout", "later", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// 未确认的消息不能标记为已发送
+	pub := &bufferingPublisher{}
+	relay := NewRelay(db, pub, &RelayConfig{MaxAttempts: 3})
+	if _, err := relay.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(pub.buffered) != 0 {
+		t.Fatalf("relay used unconfirmed publish: %v", pub.buffered)
+	}
+
+	var msgs []Message
+	if err := db.Order("id").Find(&msgs).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		if msg.Status == StatusSent {
+			t.Fatalf("message %q marked sent without confirm", msg.Body)
+		}
+	}
+
+	// 只有延迟消息时同样需要确认
+	if err := db.Where("body = ?", "now").Delete(&Message{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&Message{}).Where("body = ?", "later").Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := relay.Flush(context.Background()); err != nil || n != 0 {
+		t.Fatalf("flush = %d, %v", n, err)
+	}
+	var later Message
+	if err := db.Where("body = ?", "later").First(&later).Error; err != nil {
+		t.Fatal(err)
+	}
+	if later.Status != StatusPending || later.Attempts != 1 || len(pub.buffered) != 0 {
+		t.Fatalf("delayed message status = %d attempts = %d buffered = %v", later.Status, later.Attempts, pub.buffered)
+	}
+}
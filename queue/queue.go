@@ -2,24 +2,102 @@ package queue
 
 import (
 	"context"
+	"fmt"
 	"github.com/kmcqqq/pkg/config"
-	"github.com/streadway/amqp"
 	"time"
 )
 
-type Queue interface {
-	Init(cfg *config.ServerInfo) error                                             // 初始化队列连接和通道
+// Publisher 发布消息，Queue 和 outbox.Writer 均实现
+type Publisher interface {
 	PublishMessageByExchange(exchangeName, routingKey, message string) error       // 发送消息
 	PublishDelayMessage(routingKey, message string, delayTime time.Duration) error // 发送消息
-	ConsumeMessages(queueName string) (<-chan amqp.Delivery, error)                // 消费消息
-	Close()                                                                        // 关闭连接和通道
-	GetConsumer(queue string, handler Handler) *RabbitMQConsumer
+}
+
+type Queue interface {
+	Publisher
+	Init(cfg *config.ServerInfo) error                         // 初始化队列连接和通道
+	ConsumeMessages(queueName string) (<-chan Delivery, error) // 消费消息，需手动 Ack
+	Close()                                                    // 关闭连接和通道
+	GetConsumer(queue string, handler Handler) Consumer
+}
+
+// Consumer 订阅队列并调用 Handler 处理消息
+type Consumer interface {
+	Start(ctx context.Context) error
+	// Shutdown 停止订阅并等待处理中的消息完成，ctx 到期时返回 ctx.Err()
+	Shutdown(ctx context.Context) error
+	Stop()
+}
+
+// Delivery 与具体 broker 无关的投递消息
+type Delivery struct {
+	// MessageId 见 Message.Id
+	MessageId  string
+	Exchange   string
+	RoutingKey string
+	Headers    map[string]interface{}
+	Body       []byte
+
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+// Ack 确认消息已处理
+func (d Delivery) Ack() error {
+	if d.ack == nil {
+		return nil
+	}
+	return d.ack()
+}
+
+// Nack 拒绝消息，requeue 为 true 时重新入队
+func (d Delivery) Nack(requeue bool) error {
+	if d.nack == nil {
+		return nil
+	}
+	return d.nack(requeue)
+}
+
+// ConfirmPublisher 可选接口，支持等待 broker 确认的队列实现；MessageService 通过类型断言使用，未实现时退化为普通发布
+type ConfirmPublisher interface {
+	PublishMessageConfirm(exchangeName, routingKey, message string) error                 // 发送消息并等待 broker 确认
+	PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error // 发送延迟消息并等待 broker 确认
 }
 
 type Message struct {
 	Topic string
 	Key   string
 	Data  string
+	// Id 消息 ID，RabbitMQ 为发布时生成的 MessageId（重试时保持不变），Redis Streams 为 stream 条目 ID，用于去重
+	Id string
 }
 
 type Handler func(context.Context, *Message) error
+
+const (
+	DriverRabbitMQ = "rabbitmq"
+	DriverRedis    = "redis"
+	DriverMemory   = "memory"
+)
+
+// New 按 cfg.Driver 创建队列，之后调用 Init；memory 和 redis 在此应用 cfg.Topology 的路由，rabbitmq 在 Init 时声明
+func New(cfg *config.QueueConfig) (Queue, error) {
+	if cfg == nil {
+		cfg = &config.QueueConfig{}
+	}
+
+	switch cfg.Driver {
+	case "", DriverRabbitMQ:
+		return NewRabbitMQ(cfg), nil
+	case DriverRedis:
+		return NewRedisStream(cfg)
+	case DriverMemory:
+		m := NewMemory()
+		if err := m.Declare(cfg.Topology); err != nil {
+			return nil, err
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("queue: unknown driver %s", cfg.Driver)
+	}
+}
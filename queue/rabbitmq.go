@@ -2,69 +2,436 @@ package queue
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/kmcqqq/pkg/config"
 	"github.com/kmcqqq/pkg/logger"
 	"github.com/streadway/amqp"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	DelayExchange = "delay-exchange"
+
+	defaultReconnectDelay    = 1 * time.Second
+	defaultReconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	// ErrNotConnected 断线期间发布且未配置缓冲或缓冲已满
+	ErrNotConnected = errors.New("queue: rabbitmq is not connected")
+	// ErrClosed 已调用 Close
+	ErrClosed = errors.New("queue: rabbitmq is closed")
+)
+
+type pendingPublish struct {
+	exchange   string
+	routingKey string
+	mandatory  bool
+	msg        amqp.Publishing
+}
+
+// RabbitMQ 断线后根据 NotifyClose 自动重连，重连期间按配置缓存或直接拒绝发布
 type RabbitMQ struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
+	cfg config.QueueConfig
+	dsn string
+
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	confirms *confirmTracker
+	// ready 连接可用时关闭，断线后替换为新的 chan，用于消费者等待重连
+	ready   chan struct{}
+	pending []pendingPublish
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRabbitMQ 使用队列配置创建 RabbitMQ，之后调用 Init 连接
+func NewRabbitMQ(cfg *config.QueueConfig) *RabbitMQ {
+	r := &RabbitMQ{}
+	if cfg != nil {
+		r.cfg = *cfg
+	}
+	return r
 }
 
+// PublishDelayMessage 按 DelayStrategy 使用延迟插件或 TTL 分桶队列
 func (r *RabbitMQ) PublishDelayMessage(routingKey, message string, delayTime time.Duration) error {
-	return r.channel.Publish(
-		"delay-exchange", //exchangeName
-		routingKey,       //routing key
-		true,             //mandatory
-		false,            //immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         []byte(message),
-			Headers: amqp.Table{
-				"x-delay": int(delayTime / time.Millisecond),
-			},
-		},
-	)
+	return r.publishDelayed(routingKey, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "text/plain",
+		Body:         []byte(message),
+	}, delayTime, r.cfg.PublisherConfirm)
 }
 
 var _ Queue = &RabbitMQ{}
+var _ ConfirmPublisher = &RabbitMQ{}
 
 func (r *RabbitMQ) Init(cfg *config.ServerInfo) error {
-	dsn := fmt.Sprintf("amqp://%s:%s@%s:%d/", cfg.User, cfg.Pwd, cfg.Host, cfg.Port)
-	var err error
-	r.conn, err = amqp.Dial(dsn)
+	if r.cfg.DelayStrategy != "" && r.cfg.DelayStrategy != DelayStrategyPlugin && r.cfg.DelayStrategy != DelayStrategyTTL {
+		return fmt.Errorf("queue: unknown delay strategy %s", r.cfg.DelayStrategy)
+	}
+	if r.useTTLDelay() && len(r.delayBuckets()) == 0 {
+		return errors.New("queue: delay buckets must contain a positive duration")
+	}
+
+	r.dsn = fmt.Sprintf("amqp://%s:%s@%s:%d/", cfg.User, cfg.Pwd, cfg.Host, cfg.Port)
+	r.done = make(chan struct{})
+	r.ready = make(chan struct{})
+
+	if err := r.connect(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// connect 建立连接、声明配置的拓扑并打开发布通道，成功后补发缓存消息并开始监听断线
+func (r *RabbitMQ) connect() error {
+	conn, err := amqp.Dial(r.dsn)
 	if err != nil {
 		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
-	r.channel, err = r.conn.Channel()
+	if r.cfg.Topology != nil || r.useTTLDelay() {
+		if err := r.declareOn(conn); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	channel, err := conn.Channel()
 	if err != nil {
+		conn.Close()
 		return fmt.Errorf("failed to open a channel: %w", err)
 	}
+	confirms, err := r.initConfirms(channel)
+	if err != nil {
+		conn.Close()
+		return err
+	}
 
+	r.mu.Lock()
+	if r.isClosed() {
+		r.mu.Unlock()
+		channel.Close()
+		conn.Close()
+		return ErrClosed
+	}
+	r.conn = conn
+	r.channel = channel
+	r.confirms = confirms
+	r.flushPending()
+	close(r.ready)
+	r.mu.Unlock()
+
+	go r.watch(conn, channel)
 	return nil
 }
 
+// watch 连接或发布通道关闭后标记断线并重连，主动 Close 时退出
+func (r *RabbitMQ) watch(conn *amqp.Connection, channel *amqp.Channel) {
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	channelClosed := channel.NotifyClose(make(chan *amqp.Error, 1))
+
+	var reason *amqp.Error
+	select {
+	case <-r.done:
+		return
+	case reason = <-connClosed:
+	case reason = <-channelClosed:
+	}
+	if r.isClosed() {
+		return
+	}
+
+	// 仅发布通道被 broker 关闭（如交换机不存在）时在原连接上重建通道，不影响消费者
+	if !conn.IsClosed() {
+		if r.reopenChannel(conn) == nil {
+			logger.Warn("rabbitmq", logger.String("text", "channel reopened"), logger.Any("reason", reason))
+			return
+		}
+	}
+
+	r.mu.Lock()
+	r.conn = nil
+	r.channel = nil
+	r.confirms = nil
+	r.ready = make(chan struct{})
+	r.mu.Unlock()
+
+	conn.Close()
+	logger.Warn("rabbitmq", logger.String("text", "connection lost"), logger.Any("reason", reason))
+
+	r.reconnect()
+}
+
+func (r *RabbitMQ) declareOn(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %w", err)
+	}
+	defer channel.Close()
+
+	if err := declareTopology(channel, r.adaptTopology(r.cfg.Topology)); err != nil {
+		return err
+	}
+	if r.useTTLDelay() {
+		return declareTopology(channel, r.bucketTopology())
+	}
+	return nil
+}
+
+// reopenChannel 在仍可用的连接上替换发布通道
+func (r *RabbitMQ) reopenChannel(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	confirms, err := r.initConfirms(channel)
+	if err != nil {
+		channel.Close()
+		return err
+	}
+
+	r.mu.Lock()
+	if r.isClosed() {
+		r.mu.Unlock()
+		channel.Close()
+		return ErrClosed
+	}
+	r.channel = channel
+	r.confirms = confirms
+	r.flushPending()
+	r.mu.Unlock()
+
+	go r.watch(conn, channel)
+	return nil
+}
+
+// initConfirms 配置了 PublisherConfirm 时新通道直接开启 confirm 模式，否则在第一次需要确认的发布时开启
+func (r *RabbitMQ) initConfirms(channel *amqp.Channel) (*confirmTracker, error) {
+	if !r.cfg.PublisherConfirm {
+		return nil, nil
+	}
+	return newConfirmTracker(channel)
+}
+
+// reconnect 指数退避重连直到成功或 Close
+func (r *RabbitMQ) reconnect() {
+	delay := r.reconnectDelay()
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+
+		err := r.connect()
+		if err == nil {
+			logger.Info("rabbitmq", logger.String("text", "reconnected"), logger.Int("attempt", attempt))
+			return
+		} else if errors.Is(err, ErrClosed) {
+			return
+		}
+
+		logger.Warn("rabbitmq", logger.String("text", "reconnect failed"), logger.Int("attempt", attempt), logger.Err(err))
+		if delay *= 2; delay > r.reconnectMaxDelay() {
+			delay = r.reconnectMaxDelay()
+		}
+	}
+}
+
+func (r *RabbitMQ) reconnectDelay() time.Duration {
+	if r.cfg.ReconnectDelay > 0 {
+		return r.cfg.ReconnectDelay
+	}
+	return defaultReconnectDelay
+}
+
+func (r *RabbitMQ) reconnectMaxDelay() time.Duration {
+	if r.cfg.ReconnectMaxDelay > 0 {
+		return r.cfg.ReconnectMaxDelay
+	}
+	return defaultReconnectMaxDelay
+}
+
+func (r *RabbitMQ) isClosed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitConnected 阻塞到连接可用
+func (r *RabbitMQ) waitConnected(ctx context.Context) error {
+	r.mu.Lock()
+	ready := r.ready
+	r.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-r.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// openChannel 在当前连接上打开新通道，供消费者使用
+func (r *RabbitMQ) openChannel() (*amqp.Channel, error) {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+	return conn.Channel()
+}
+
 func (r *RabbitMQ) PublishMessageByExchange(exchangeName, routingKey, message string) error {
-	return r.channel.Publish(
-		exchangeName, //exchangeName
-		routingKey,   //routing key
-		true,         //mandatory
-		false,        //immediate
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(message),
-		},
-	)
+	return r.publish(exchangeName, routingKey, true, r.cfg.PublisherConfirm, amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        []byte(message),
+	})
+}
+
+// PublishMessageConfirm 无论是否配置 PublisherConfirm 都等待 broker 确认，nack 或无法路由返回错误，断线时不缓存
+func (r *RabbitMQ) PublishMessageConfirm(exchangeName, routingKey, message string) error {
+	return r.publish(exchangeName, routingKey, true, true, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "text/plain",
+		Body:         []byte(message),
+	})
+}
+
+// PublishDelayMessageConfirm 与 PublishDelayMessage 相同但总是等待 broker 确认，断线时不缓存
+func (r *RabbitMQ) PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error {
+	return r.publishDelayed(routingKey, amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		ContentType:  "text/plain",
+		Body:         []byte(message),
+	}, delayTime, true)
+}
+
+// publish 串行发布，wait 为 true 时释放锁后等待确认；不等待确认的消息断线时按 PublishBuffer 缓存或返回 ErrNotConnected
+func (r *RabbitMQ) publish(exchange, routingKey string, mandatory, wait bool, msg amqp.Publishing) error {
+	r.mu.Lock()
+
+	if r.done == nil {
+		r.mu.Unlock()
+		return ErrNotConnected
+	}
+	if r.isClosed() {
+		r.mu.Unlock()
+		return ErrClosed
+	}
+
+	if r.channel == nil {
+		defer r.mu.Unlock()
+		if wait {
+			return ErrNotConnected
+		}
+		return r.bufferLocked(exchange, routingKey, mandatory, msg)
+	}
+
+	done, err := r.publishLocked(exchange, routingKey, mandatory, wait, msg)
+	if errors.Is(err, amqp.ErrClosed) && !wait {
+		err = r.bufferLocked(exchange, routingKey, mandatory, msg)
+	}
+	r.mu.Unlock()
+
+	if err != nil || !wait {
+		return err
+	}
+	return r.waitConfirm(done)
+}
+
+// publishLocked 在当前通道发布，调用方需持有 mu；通道未开启 confirm 模式时不跟踪确认，无法路由的消息被 broker 丢弃
+func (r *RabbitMQ) publishLocked(exchange, routingKey string, mandatory, wait bool, msg amqp.Publishing) (chan error, error) {
+	if msg.MessageId == "" {
+		msg.MessageId = uuid.New().String()
+	}
+
+	if r.confirms == nil {
+		if !wait {
+			return nil, r.channel.Publish(exchange, routingKey, mandatory, false, msg)
+		}
+		confirms, err := newConfirmTracker(r.channel)
+		if err != nil {
+			return nil, err
+		}
+		r.confirms = confirms
+	}
+
+	// 退回的消息按 MessageId 对应到等待者
+	tag, done := r.confirms.reserve(wait, msg.MessageId)
+	if err := r.channel.Publish(exchange, routingKey, mandatory, false, msg); err != nil {
+		r.confirms.cancel(tag)
+		return nil, err
+	}
+	r.confirms.published()
+	return done, nil
+}
+
+func (r *RabbitMQ) waitConfirm(done <-chan error) error {
+	timeout := r.cfg.ConfirmTimeout
+	if timeout <= 0 {
+		timeout = defaultConfirmTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrConfirmTimeout
+	}
+}
+
+func (r *RabbitMQ) bufferLocked(exchange, routingKey string, mandatory bool, msg amqp.Publishing) error {
+	if len(r.pending) >= r.cfg.PublishBuffer {
+		return ErrNotConnected
+	}
+	r.pending = append(r.pending, pendingPublish{exchange: exchange, routingKey: routingKey, mandatory: mandatory, msg: msg})
+	return nil
+}
+
+// flushPending 重连后按顺序补发缓存的消息，失败的保留到下次重连；补发不等待确认，nack 和退回记录日志
+func (r *RabbitMQ) flushPending() {
+	for i, p := range r.pending {
+		if _, err := r.publishLocked(p.exchange, p.routingKey, p.mandatory, false, p.msg); err != nil {
+			logger.Error("rabbitmq", logger.String("text", "flush pending publish failed"), logger.Int("remaining", len(r.pending)-i), logger.Err(err))
+			r.pending = r.pending[i:]
+			return
+		}
+	}
+	if len(r.pending) > 0 {
+		logger.Info("rabbitmq", logger.String("text", "flushed pending publish"), logger.Int("count", len(r.pending)))
+	}
+	r.pending = nil
 }
 
-func (r *RabbitMQ) ConsumeMessages(queueName string) (<-chan amqp.Delivery, error) {
+// ConsumeMessages 在当前发布通道上消费，断线后返回的 chan 会关闭且不会自动恢复，需要自动恢复请使用 GetConsumer
+func (r *RabbitMQ) ConsumeMessages(queueName string) (<-chan Delivery, error) {
+	r.mu.Lock()
+	channel := r.channel
+	r.mu.Unlock()
+
+	if channel == nil {
+		return nil, ErrNotConnected
+	}
 	//	实现 rabbitmq 消费
-	return r.channel.Consume(
+	msgs, err := channel.Consume(
 		queueName, // queue
 		"",        // consumer
 		false,     // auto-ack
@@ -73,59 +440,385 @@ func (r *RabbitMQ) ConsumeMessages(queueName string) (<-chan amqp.Delivery, erro
 		false,     // no-wait
 		nil,       // args
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for msg := range msgs {
+			deliveries <- newAmqpDelivery(msg)
+		}
+	}()
+	return deliveries, nil
 }
 
-func (r *RabbitMQ) Close() {
-	if r.channel != nil {
-		r.channel.Close()
-	}
-	if r.conn != nil {
-		r.conn.Close()
+func newAmqpDelivery(msg amqp.Delivery) Delivery {
+	return Delivery{
+		MessageId:  msg.MessageId,
+		Exchange:   msg.Exchange,
+		RoutingKey: msg.RoutingKey,
+		Headers:    msg.Headers,
+		Body:       msg.Body,
+		ack: func() error {
+			return msg.Ack(false)
+		},
+		nack: func(requeue bool) error {
+			return msg.Nack(false, requeue)
+		},
 	}
 }
 
+func (r *RabbitMQ) Close() {
+	r.closeOnce.Do(func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.done != nil {
+			close(r.done)
+		}
+		if len(r.pending) > 0 {
+			logger.Warn("rabbitmq", logger.String("text", "dropping pending publish on close"), logger.Int("count", len(r.pending)))
+			r.pending = nil
+		}
+		if r.channel != nil {
+			r.channel.Close()
+		}
+		if r.conn != nil {
+			r.conn.Close()
+		}
+	})
+}
+
+// ConsumerConfig 消费者配置，为空时自动 ack、单协程处理；设置了 Retry、Concurrency 大于 1 或 Prefetch 时手动 ack
+type ConsumerConfig struct {
+	// Retry 不为空时失败按策略重试并最终转入死信队列；为空时失败的消息记录日志后 ack 丢弃
+	Retry *RetryPolicy
+	// Concurrency 并发处理的协程数，默认 1；大于 1 时消息处理顺序不再保证
+	Concurrency int
+	// Prefetch 通道 Qos 预取数，0 时 Concurrency 大于 1 则取 Concurrency，否则不限制
+	Prefetch int
+	// Middleware 按顺序包装 Handler，第一个在最外层
+	Middleware []Middleware
+}
+
+var consumerSeq uint64
+
+// RabbitMQConsumer 每个消费者使用独立通道，断线重连后自动重新订阅
 type RabbitMQConsumer struct {
 	client  *RabbitMQ
 	queue   string
 	handler Handler
+	cfg     ConsumerConfig
+	tag     string
+
+	mu       sync.Mutex
+	channel  *amqp.Channel
+	started  bool
+	stopping bool
+	// stop Shutdown 或 ctx 取消时关闭，done 在所有处理协程退出后关闭
+	stop chan struct{}
+	done chan struct{}
 }
 
-func (r *RabbitMQ) GetConsumer(queue string, handler Handler) *RabbitMQConsumer {
-	return &RabbitMQConsumer{
+var _ Consumer = &RabbitMQConsumer{}
+
+func (r *RabbitMQ) GetConsumer(queue string, handler Handler) Consumer {
+	return r.NewConsumer(queue, handler, nil)
+}
+
+// NewConsumer 按配置创建消费者
+func (r *RabbitMQ) NewConsumer(queue string, handler Handler, cfg *ConsumerConfig) *RabbitMQConsumer {
+	c := &RabbitMQConsumer{
 		client:  r,
 		queue:   queue,
 		handler: handler,
+		tag:     fmt.Sprintf("%s-%d", queue, atomic.AddUint64(&consumerSeq, 1)),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if cfg != nil {
+		c.cfg = *cfg
+		c.handler = Chain(handler, cfg.Middleware...)
 	}
+	return c
 }
 
+// Start 订阅并在后台处理消息，ctx 取消后与 Shutdown 一样停止订阅并等待处理中的消息完成
 func (c *RabbitMQConsumer) Start(ctx context.Context) error {
-	msgs, err := c.client.channel.Consume(
-		c.queue,
-		"",    // consumer
-		true,  // auto-ack
-		false, // exclusive
-		false, // no-local
-		false, // no-wait
-		nil,   // args
-	)
+	c.mu.Lock()
+	if c.started || c.stopping {
+		c.mu.Unlock()
+		return errors.New("queue: consumer already started or stopped")
+	}
+	c.started = true
+	c.mu.Unlock()
+
+	channel, msgs, err := c.subscribe()
 	if err != nil {
+		c.mu.Lock()
+		c.started = false
+		c.mu.Unlock()
 		return fmt.Errorf("failed to register a consumer: %w", err)
 	}
+	if !c.setChannel(channel) {
+		channel.Close()
+		close(c.done)
+		return ErrClosed
+	}
 
 	go func() {
-		for msg := range msgs {
-			//logger.Debug("consumer", logger.String("topic", c.queue), logger.String("key", msg.RoutingKey), logger.String("data", string(msg.Body)))
+		select {
+		case <-ctx.Done():
+			c.cancel()
+		case <-c.done:
+		}
+	}()
+	go c.run(ctx, msgs)
+	return nil
+}
+
+// Stop 等同 Shutdown(context.Background())
+func (c *RabbitMQConsumer) Stop() {
+	c.Shutdown(context.Background())
+}
+
+// Shutdown 取消订阅（consumer tag），等待已收到的消息处理完成；ctx 到期时关闭通道并返回 ctx.Err()，
+// 手动 ack 模式下未确认的消息由 broker 重新投递
+func (c *RabbitMQConsumer) Shutdown(ctx context.Context) error {
+	c.cancel()
+
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		if c.channel != nil {
+			c.channel.Close()
+		}
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// cancel 标记停止并取消当前通道上的订阅，通道中已缓冲的消息仍会交给处理协程
+func (c *RabbitMQConsumer) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopping {
+		return
+	}
+	c.stopping = true
+	close(c.stop)
+
+	if c.channel == nil {
+		return
+	}
+	if err := c.channel.Cancel(c.tag, false); err != nil {
+		logger.Warn("rabbitmq", logger.String("text", "cancel consumer failed"), logger.String("topic", c.queue), logger.Err(err))
+		c.channel.Close()
+	}
+}
 
-			message := &Message{
-				Topic: c.queue,
-				Key:   msg.RoutingKey,
-				Data:  string(msg.Body),
+// setChannel 记录当前通道，已停止时返回 false
+func (c *RabbitMQConsumer) setChannel(channel *amqp.Channel) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopping {
+		return false
+	}
+	c.channel = channel
+	return true
+}
+
+func (c *RabbitMQConsumer) subscribe() (*amqp.Channel, <-chan amqp.Delivery, error) {
+	channel, err := c.client.openChannel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.cfg.Retry != nil {
+		if err := c.declareRetry(channel); err != nil {
+			channel.Close()
+			return nil, nil, err
+		}
+	}
+	if prefetch := c.prefetch(); prefetch > 0 {
+		if err := channel.Qos(prefetch, 0, false); err != nil {
+			channel.Close()
+			return nil, nil, err
+		}
+	}
+
+	msgs, err := channel.Consume(
+		c.queue,
+		c.tag,          // consumer
+		!c.manualAck(), // auto-ack
+		false,          // exclusive
+		false,          // no-local
+		false,          // no-wait
+		nil,            // args
+	)
+	if err != nil {
+		channel.Close()
+		return nil, nil, err
+	}
+	return channel, msgs, nil
+}
+
+// run 处理消息，deliveries 关闭（断线）后等待重连并重新订阅，停止或 Close 后退出
+func (c *RabbitMQConsumer) run(ctx context.Context, msgs <-chan amqp.Delivery) {
+	defer close(c.done)
+
+	for {
+		c.consume(ctx, msgs)
+
+		c.mu.Lock()
+		if c.channel != nil {
+			c.channel.Close()
+			c.channel = nil
+		}
+		stopping := c.stopping
+		c.mu.Unlock()
+
+		if stopping || c.client.isClosed() {
+			return
+		}
+		logger.Warn("rabbitmq", logger.String("text", "consumer disconnected"), logger.String("topic", c.queue))
+
+		var channel *amqp.Channel
+		var err error
+		delay := c.client.reconnectDelay()
+		for {
+			if err = c.waitConnected(); err != nil {
+				return
 			}
-			if err := c.handler(ctx, message); err != nil {
-				logger.Error("error", logger.String("title", "consumer error"), logger.String("topic", c.queue), logger.String("key", msg.RoutingKey), logger.String("data", string(msg.Body)), logger.Err(err))
+			if channel, msgs, err = c.subscribe(); err == nil {
+				break
 			}
+			logger.Warn("rabbitmq", logger.String("text", "consumer resubscribe failed"), logger.String("topic", c.queue), logger.Err(err))
+
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > c.client.reconnectMaxDelay() {
+				delay = c.client.reconnectMaxDelay()
+			}
+		}
+		if !c.setChannel(channel) {
+			channel.Close()
+			return
+		}
+		logger.Info("rabbitmq", logger.String("text", "consumer resubscribed"), logger.String("topic", c.queue))
+	}
+}
+
+// waitConnected 等待连接恢复，停止时返回错误
+func (c *RabbitMQConsumer) waitConnected() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
+	return c.client.waitConnected(ctx)
+}
 
-	return nil
+// consume 启动 Concurrency 个协程处理 msgs，订阅取消或断线后 msgs 关闭，等待全部处理完成后返回
+func (c *RabbitMQConsumer) consume(ctx context.Context, msgs <-chan amqp.Delivery) {
+	workers := c.cfg.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for msg := range msgs {
+				c.handle(ctx, msg)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// manualAck 自动 ack 时 broker 不受 Qos 限制地推送，并发处理或限制预取时必须手动 ack
+func (c *RabbitMQConsumer) manualAck() bool {
+	return c.cfg.Retry != nil || c.cfg.Concurrency > 1 || c.cfg.Prefetch > 0
+}
+
+func (c *RabbitMQConsumer) prefetch() int {
+	if c.cfg.Prefetch > 0 {
+		return c.cfg.Prefetch
+	}
+	if c.cfg.Concurrency > 1 {
+		return c.cfg.Concurrency
+	}
+	return 0
+}
+
+// handle 手动 ack 模式下停止后不再处理新消息，ctx 取消导致的失败不计入重试次数，均 requeue 交给 broker 重新投递
+func (c *RabbitMQConsumer) handle(ctx context.Context, msg amqp.Delivery) {
+	manualAck := c.manualAck()
+	if manualAck && c.isStopping() {
+		msg.Nack(false, true)
+		return
+	}
+
+	//logger.Debug("consumer", logger.String("topic", c.queue), logger.String("key", msg.RoutingKey), logger.String("data", string(msg.Body)))
+
+	key := msg.RoutingKey
+	if original, ok := msg.Headers[OriginalKeyHeader].(string); ok {
+		key = original
+	}
+	message := &Message{
+		Topic: c.queue,
+		Key:   key,
+		Data:  string(msg.Body),
+		Id:    msg.MessageId,
+	}
+	err := c.handler(ctx, message)
+	if err != nil {
+		logger.Error("error", logger.String("title", "consumer error"), logger.String("topic", c.queue), logger.String("key", key), logger.String("data", string(msg.Body)), logger.Err(err))
+	}
+	if !manualAck {
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		msg.Nack(false, true)
+		return
+	}
+	if c.cfg.Retry == nil {
+		if err := msg.Ack(false); err != nil {
+			logger.Error("rabbitmq", logger.String("text", "ack failed"), logger.String("topic", c.queue), logger.Err(err))
+		}
+		return
+	}
+	c.settle(msg, err)
+}
+
+func (c *RabbitMQConsumer) isStopping() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
 }
@@ -0,0 +1,92 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/streadway/amqp"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(&config.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+// fakeAcknowledger 记录 ack/nack 调用
+type fakeAcknowledger struct {
+	acked, nacked, requeued int
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked++
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked++
+	if requeue {
+		a.requeued++
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestConsumerAckMode(t *testing.T) {
+	r := &RabbitMQ{}
+	cases := []struct {
+		cfg      *ConsumerConfig
+		manual   bool
+		prefetch int
+	}{
+		{nil, false, 0},
+		{&ConsumerConfig{Concurrency: 1}, false, 0},
+		{&ConsumerConfig{Concurrency: 8}, true, 8},
+		{&ConsumerConfig{Concurrency: 8, Prefetch: 32}, true, 32},
+		{&ConsumerConfig{Prefetch: 10}, true, 10},
+		{&ConsumerConfig{Retry: &RetryPolicy{}}, true, 0},
+	}
+	for i, tc := range cases {
+		c := r.NewConsumer("q", nil, tc.cfg)
+		if c.manualAck() != tc.manual || c.prefetch() != tc.prefetch {
+			t.Errorf("case %d: manualAck=%v prefetch=%d, want %v %d", i, c.manualAck(), c.prefetch(), tc.manual, tc.prefetch)
+		}
+	}
+}
+
+func TestConsumerManualAckWithoutRetry(t *testing.T) {
+	handlerErr := errors.New("boom")
+	c := (&RabbitMQ{}).NewConsumer("q", func(ctx context.Context, msg *Message) error {
+		if msg.Data == "fail" {
+			return handlerErr
+		}
+		return nil
+	}, &ConsumerConfig{Concurrency: 4})
+
+	ack := &fakeAcknowledger{}
+	c.handle(context.Background(), amqp.Delivery{Acknowledger: ack, Body: []byte("ok")})
+	c.handle(context.Background(), amqp.Delivery{Acknowledger: ack, Body: []byte("fail")})
+	if ack.acked != 2 || ack.nacked != 0 {
+		t.Fatalf("without retry every message should be acked once handled: %+v", ack)
+	}
+
+	// 取消导致的失败交回 broker
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.handle(ctx, amqp.Delivery{Acknowledger: ack, Body: []byte("fail")})
+	if ack.requeued != 1 {
+		t.Fatalf("cancelled failure should be requeued: %+v", ack)
+	}
+
+	// 停止后未处理的消息交回 broker
+	c.Stop()
+	c.handle(context.Background(), amqp.Delivery{Acknowledger: ack, Body: []byte("ok")})
+	if ack.requeued != 2 || ack.acked != 2 {
+		t.Fatalf("message received after stop should be requeued: %+v", ack)
+	}
+}
@@ -0,0 +1,627 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/kmcqqq/pkg/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/streadway/amqp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultStreamPrefix      = "queue:"
+	defaultStreamGroup       = "default"
+	defaultStreamBlock       = 2 * time.Second
+	defaultStreamClaimIdle   = 1 * time.Minute
+	defaultDelayPollInterval = 1 * time.Second
+	defaultMaxDeliveries     = 5
+
+	streamReadCount  = 10
+	delayedBatchSize = 100
+)
+
+// moveDueScript 把到期的延迟消息原子地写入目标 stream 并从 ZSET 删除，多实例同时扫描不会重复投递。
+// 目标 stream 未通过 KEYS 传入，不支持 Redis Cluster
+var moveDueScript = goredis.NewScript(`
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+local maxlen = tonumber(ARGV[3])
+for _, item in ipairs(items) do
+	local msg = cjson.decode(item)
+	for _, stream in ipairs(msg.streams) do
+		if maxlen > 0 then
+			redis.call('XADD', stream, 'MAXLEN', '~', maxlen, '*', 'exchange', msg.exchange, 'key', msg.key, 'data', msg.data)
+		else
+			redis.call('XADD', stream, '*', 'exchange', msg.exchange, 'key', msg.key, 'data', msg.data)
+		end
+	end
+	redis.call('ZREM', KEYS[1], item)
+end
+return #items
+`)
+
+// RedisStream 基于 Redis Streams 的 Queue 实现，每个队列对应一个 stream，
+// 交换机路由按 Topology 在发布端计算；消费组内竞争消费，未 ack 的消息超过 ClaimIdle 后被重新认领。
+// 认领依赖 XPENDING IDLE，需要 Redis 6.2 及以上
+type RedisStream struct {
+	cfg    config.RedisStreamConfig
+	client *goredis.Client
+
+	mu     sync.Mutex
+	router *router
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+var _ Queue = &RedisStream{}
+var _ ConfirmPublisher = &RedisStream{}
+
+// NewRedisStream 使用队列配置创建，Init 后可用
+func NewRedisStream(cfg *config.QueueConfig) (*RedisStream, error) {
+	s := &RedisStream{
+		router: newRouter(true),
+		done:   make(chan struct{}),
+	}
+	if cfg != nil {
+		s.cfg = cfg.Redis
+		if err := s.router.declare(cfg.Topology); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.cfg.Prefix == "" {
+		s.cfg.Prefix = defaultStreamPrefix
+	}
+	if s.cfg.Group == "" {
+		s.cfg.Group = defaultStreamGroup
+	}
+	if s.cfg.Consumer == "" {
+		hostname, _ := os.Hostname()
+		s.cfg.Consumer = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if s.cfg.Block <= 0 {
+		s.cfg.Block = defaultStreamBlock
+	}
+	if s.cfg.ClaimIdle <= 0 {
+		s.cfg.ClaimIdle = defaultStreamClaimIdle
+	}
+	if s.cfg.DelayPollInterval <= 0 {
+		s.cfg.DelayPollInterval = defaultDelayPollInterval
+	}
+	if s.cfg.MaxDeliveries == 0 {
+		s.cfg.MaxDeliveries = defaultMaxDeliveries
+	}
+	return s, nil
+}
+
+// Init 使用 redis.GetClient，需先调用 redis.Initialize；cfg 未使用
+func (s *RedisStream) Init(cfg *config.ServerInfo) error {
+	s.client = redis.GetClient()
+	if s.client == nil {
+		return errors.New("queue: redis is not initialized")
+	}
+
+	s.wg.Add(1)
+	go s.pollDelayed()
+	return nil
+}
+
+// Declare 追加路由拓扑，Redis 中无需创建任何结构
+func (s *RedisStream) Declare(topology *config.QueueTopology) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.router.declare(topology)
+}
+
+func (s *RedisStream) streamKey(queue string) string {
+	return s.cfg.Prefix + queue
+}
+
+func (s *RedisStream) delayedKey() string {
+	return s.cfg.Prefix + "delayed"
+}
+
+// targets 计算目标 stream
+func (s *RedisStream) targets(exchange, key string) ([]string, error) {
+	s.mu.Lock()
+	queues, err := s.router.route(exchange, key)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	streams := make([]string, len(queues))
+	for i, q := range queues {
+		streams[i] = s.streamKey(q)
+	}
+	return streams, nil
+}
+
+// publish 返回写入的 stream 数
+func (s *RedisStream) publish(exchange, key, message string) (int, error) {
+	if s.client == nil {
+		return 0, ErrNotConnected
+	}
+	streams, err := s.targets(exchange, key)
+	if err != nil || len(streams) == 0 {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	_, err = s.client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for _, stream := range streams {
+			pipe.XAdd(ctx, s.xaddArgs(stream, exchange, key, message))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(streams), nil
+}
+
+func (s *RedisStream) xaddArgs(stream, exchange, key, data string) *goredis.XAddArgs {
+	return &goredis.XAddArgs{
+		Stream: stream,
+		MaxLen: s.cfg.MaxLen,
+		Approx: s.cfg.MaxLen > 0,
+		Values: map[string]interface{}{"exchange": exchange, "key": key, "data": data},
+	}
+}
+
+func (s *RedisStream) PublishMessageByExchange(exchangeName, routingKey, message string) error {
+	n, err := s.publish(exchangeName, routingKey, message)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		logger.Warn("redis queue", logger.String("text", "unroutable message"), logger.String("exchange", exchangeName), logger.String("key", routingKey))
+	}
+	return nil
+}
+
+// PublishMessageConfirm XADD 成功即视为确认，无法路由时返回 ReturnError
+func (s *RedisStream) PublishMessageConfirm(exchangeName, routingKey, message string) error {
+	n, err := s.publish(exchangeName, routingKey, message)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &ReturnError{Exchange: exchangeName, RoutingKey: routingKey, ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE"}
+	}
+	return nil
+}
+
+// PublishDelayMessageConfirm 写入 ZSET 成功即视为确认，无法路由时返回 ReturnError
+func (s *RedisStream) PublishDelayMessageConfirm(routingKey, message string, delayTime time.Duration) error {
+	if s.client == nil {
+		return ErrNotConnected
+	}
+	streams, err := s.targets(DelayExchange, routingKey)
+	if err != nil {
+		return err
+	}
+	if len(streams) == 0 {
+		return &ReturnError{Exchange: DelayExchange, RoutingKey: routingKey, ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE"}
+	}
+	return s.PublishDelayMessage(routingKey, message, delayTime)
+}
+
+type delayedMessage struct {
+	Id       string   `json:"id"`
+	Streams  []string `json:"streams"`
+	Exchange string   `json:"exchange"`
+	Key      string   `json:"key"`
+	Data     string   `json:"data"`
+}
+
+// PublishDelayMessage 写入 ZSET，score 为到期时间毫秒；路由在发布时计算
+func (s *RedisStream) PublishDelayMessage(routingKey, message string, delayTime time.Duration) error {
+	if s.client == nil {
+		return ErrNotConnected
+	}
+	streams, err := s.targets(DelayExchange, routingKey)
+	if err != nil {
+		return err
+	}
+	if len(streams) == 0 {
+		logger.Warn("redis queue", logger.String("text", "unroutable delayed message"), logger.String("key", routingKey))
+		return nil
+	}
+
+	now := time.Now()
+	member, err := json.Marshal(delayedMessage{
+		// Id 保证相同内容的消息在 ZSET 中不会合并
+		Id:       fmt.Sprintf("%d-%s", now.UnixNano(), s.cfg.Consumer),
+		Streams:  streams,
+		Exchange: DelayExchange,
+		Key:      routingKey,
+		Data:     message,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.client.ZAdd(context.Background(), s.delayedKey(), goredis.Z{
+		Score:  float64(now.Add(delayTime).UnixMilli()),
+		Member: string(member),
+	}).Err()
+}
+
+// pollDelayed 定时把到期的延迟消息写入目标 stream
+func (s *RedisStream) pollDelayed() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.DelayPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := moveDueScript.Run(context.Background(), s.client, []string{s.delayedKey()}, time.Now().UnixMilli(), delayedBatchSize, s.cfg.MaxLen).Int()
+			if err != nil {
+				logger.Error("redis queue", logger.String("text", "move delayed messages failed"), logger.Err(err))
+				break
+			}
+			if n < delayedBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// ensureGroup 创建消费组，stream 不存在时一并创建；从头开始读取，队列声明后、消费者启动前的消息也会被消费
+func (s *RedisStream) ensureGroup(ctx context.Context, stream string) error {
+	err := s.client.XGroupCreateMkStream(ctx, stream, s.cfg.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// ConsumeMessages 以本实例的消费者名读取，Ack 执行 XACK；Nack(false) 直接 XACK 丢弃，Nack(true) 留在 pending，
+// 空闲超过 ClaimIdle 后与崩溃实例遗留的消息一起被重新认领投递；Close 后返回的 chan 关闭
+func (s *RedisStream) ConsumeMessages(queueName string) (<-chan Delivery, error) {
+	if s.client == nil {
+		return nil, ErrNotConnected
+	}
+	stream := s.streamKey(queueName)
+	if err := s.ensureGroup(context.Background(), stream); err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan Delivery)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(deliveries)
+		var lastClaim time.Time
+		for {
+			var msgs []goredis.XMessage
+			var err error
+			if s.claimDue(&lastClaim) {
+				if msgs, err = s.reclaim(context.Background(), stream); err != nil {
+					logger.Error("redis queue", logger.String("text", "reclaim failed"), logger.String("stream", stream), logger.Err(err))
+				}
+			}
+			if len(msgs) == 0 {
+				msgs, err = s.read(context.Background(), stream)
+			}
+			if err != nil {
+				if s.isClosed() {
+					return
+				}
+				logger.Error("redis queue", logger.String("text", "read stream failed"), logger.String("stream", stream), logger.Err(err))
+				select {
+				case <-s.done:
+					return
+				case <-time.After(s.cfg.Block):
+				}
+				continue
+			}
+			for _, msg := range msgs {
+				select {
+				case deliveries <- s.delivery(stream, msg):
+				case <-s.done:
+					return
+				}
+			}
+			if s.isClosed() {
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+// claimDue 认领间隔取 ClaimIdle 的一半，崩溃实例的消息最迟约 1.5 倍 ClaimIdle 后被处理
+func (s *RedisStream) claimDue(lastClaim *time.Time) bool {
+	if time.Since(*lastClaim) < s.cfg.ClaimIdle/2 {
+		return false
+	}
+	*lastClaim = time.Now()
+	return true
+}
+
+// read XREADGROUP 读取新消息，超时返回空
+func (s *RedisStream) read(ctx context.Context, stream string) ([]goredis.XMessage, error) {
+	res, err := s.client.XReadGroup(ctx, &goredis.XReadGroupArgs{
+		Group:    s.cfg.Group,
+		Consumer: s.cfg.Consumer,
+		Streams:  []string{stream, ">"},
+		Count:    streamReadCount,
+		Block:    s.cfg.Block,
+	}).Result()
+	if errors.Is(err, goredis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []goredis.XMessage
+	for _, r := range res {
+		msgs = append(msgs, r.Messages...)
+	}
+	return msgs, nil
+}
+
+// reclaim 认领空闲超过 ClaimIdle 的 pending 消息（消费者崩溃或处理失败），投递次数超限的转入死信 stream
+func (s *RedisStream) reclaim(ctx context.Context, stream string) ([]goredis.XMessage, error) {
+	pending, err := s.client.XPendingExt(ctx, &goredis.XPendingExtArgs{
+		Stream: stream,
+		Group:  s.cfg.Group,
+		Idle:   s.cfg.ClaimIdle,
+		Start:  "-",
+		End:    "+",
+		Count:  streamReadCount,
+	}).Result()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(pending))
+	exceeded := make(map[string]bool)
+	for _, p := range pending {
+		ids = append(ids, p.ID)
+		if s.cfg.MaxDeliveries > 0 && p.RetryCount >= s.cfg.MaxDeliveries {
+			exceeded[p.ID] = true
+		}
+	}
+
+	msgs, err := s.client.XClaim(ctx, &goredis.XClaimArgs{
+		Stream:   stream,
+		Group:    s.cfg.Group,
+		Consumer: s.cfg.Consumer,
+		MinIdle:  s.cfg.ClaimIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	claimed := msgs[:0]
+	for _, msg := range msgs {
+		// 已被 MAXLEN 裁剪的消息只剩 ID，直接 ack
+		if msg.Values == nil {
+			s.client.XAck(ctx, stream, s.cfg.Group, msg.ID)
+			continue
+		}
+		if exceeded[msg.ID] {
+			s.deadLetter(ctx, stream, msg)
+			continue
+		}
+		claimed = append(claimed, msg)
+	}
+	return claimed, nil
+}
+
+// deadLetter 写入 <stream>.dlq 后 ack 原消息
+func (s *RedisStream) deadLetter(ctx context.Context, stream string, msg goredis.XMessage) {
+	values := make(map[string]interface{}, len(msg.Values)+1)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values["source"] = stream
+
+	err := s.client.XAdd(ctx, &goredis.XAddArgs{
+		Stream: stream + ".dlq",
+		MaxLen: s.cfg.MaxLen,
+		Approx: s.cfg.MaxLen > 0,
+		Values: values,
+	}).Err()
+	if err == nil {
+		err = s.client.XAck(ctx, stream, s.cfg.Group, msg.ID).Err()
+	}
+	if err != nil {
+		logger.Error("redis queue", logger.String("text", "dead letter failed"), logger.String("stream", stream), logger.String("id", msg.ID), logger.Err(err))
+		return
+	}
+	logger.Error("redis queue", logger.String("text", "dead letter message"), logger.String("stream", stream), logger.String("id", msg.ID), logger.Any("data", msg.Values["data"]))
+}
+
+func (s *RedisStream) delivery(stream string, msg goredis.XMessage) Delivery {
+	exchange, _ := msg.Values["exchange"].(string)
+	key, _ := msg.Values["key"].(string)
+	data, _ := msg.Values["data"].(string)
+	ack := func() error {
+		return s.client.XAck(context.Background(), stream, s.cfg.Group, msg.ID).Err()
+	}
+	return Delivery{
+		MessageId:  msg.ID,
+		Exchange:   exchange,
+		RoutingKey: key,
+		Headers:    map[string]interface{}{"x-stream-id": msg.ID},
+		Body:       []byte(data),
+		ack:        ack,
+		nack: func(requeue bool) error {
+			if requeue {
+				return nil
+			}
+			return ack()
+		},
+	}
+}
+
+// Close 停止延迟消息轮询和 ConsumeMessages 的读取协程，最长等待一个 Block 周期
+func (s *RedisStream) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	s.wg.Wait()
+}
+
+func (s *RedisStream) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *RedisStream) GetConsumer(queue string, handler Handler) Consumer {
+	return &RedisStreamConsumer{
+		stream:  s,
+		queue:   queue,
+		handler: handler,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// RedisStreamConsumer 单协程读取新消息并定期认领超时的 pending 消息，成功 XACK，失败保留在 pending 中等待重试
+type RedisStreamConsumer struct {
+	stream  *RedisStream
+	queue   string
+	handler Handler
+
+	mu       sync.Mutex
+	started  bool
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var _ Consumer = &RedisStreamConsumer{}
+
+func (c *RedisStreamConsumer) Start(ctx context.Context) error {
+	if c.stream.client == nil {
+		return ErrNotConnected
+	}
+	key := c.stream.streamKey(c.queue)
+	if err := c.stream.ensureGroup(ctx, key); err != nil {
+		return fmt.Errorf("failed to register a consumer: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.started {
+		return errors.New("queue: consumer already started")
+	}
+	c.started = true
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.stream.done:
+		case <-c.stop:
+			return
+		}
+		c.stopOnce.Do(func() { close(c.stop) })
+	}()
+	go c.run(ctx, key)
+	return nil
+}
+
+func (c *RedisStreamConsumer) run(ctx context.Context, stream string) {
+	defer close(c.done)
+
+	var lastClaim time.Time
+	for !c.isStopping() {
+		if c.stream.claimDue(&lastClaim) {
+			msgs, err := c.stream.reclaim(context.Background(), stream)
+			if err != nil {
+				logger.Error("redis queue", logger.String("text", "reclaim failed"), logger.String("stream", stream), logger.Err(err))
+			}
+			c.handle(ctx, stream, msgs)
+		}
+
+		msgs, err := c.stream.read(context.Background(), stream)
+		if err != nil {
+			logger.Error("redis queue", logger.String("text", "read stream failed"), logger.String("stream", stream), logger.Err(err))
+			select {
+			case <-c.stop:
+			case <-time.After(c.stream.cfg.Block):
+			}
+			continue
+		}
+		c.handle(ctx, stream, msgs)
+	}
+}
+
+func (c *RedisStreamConsumer) handle(ctx context.Context, stream string, msgs []goredis.XMessage) {
+	for _, msg := range msgs {
+		key, _ := msg.Values["key"].(string)
+		data, _ := msg.Values["data"].(string)
+		message := &Message{
+			Topic: c.queue,
+			Key:   key,
+			Data:  data,
+			Id:    msg.ID,
+		}
+		if err := c.handler(ctx, message); err != nil {
+			logger.Error("error", logger.String("title", "consumer error"), logger.String("topic", c.queue), logger.String("key", key), logger.String("data", data), logger.Err(err))
+			continue
+		}
+		if err := c.stream.client.XAck(context.Background(), stream, c.stream.cfg.Group, msg.ID).Err(); err != nil {
+			logger.Error("redis queue", logger.String("text", "ack failed"), logger.String("stream", stream), logger.String("id", msg.ID), logger.Err(err))
+		}
+	}
+}
+
+func (c *RedisStreamConsumer) isStopping() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *RedisStreamConsumer) Stop() {
+	c.Shutdown(context.Background())
+}
+
+// Shutdown 停止读取并等待当前批次处理完成，最长等待一个 Block 周期加处理时间
+func (c *RedisStreamConsumer) Shutdown(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+
+	c.mu.Lock()
+	started := c.started
+	c.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
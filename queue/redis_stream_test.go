@@ -0,0 +1,170 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/kmcqqq/pkg/config"
+	goredis "github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newTestRedisStream(t *testing.T, cfg config.RedisStreamConfig) (*RedisStream, *goredis.Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	s, err := NewRedisStream(&config.QueueConfig{Redis: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.client = client
+	t.Cleanup(s.Close)
+	return s, client
+}
+
+func receive(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(3 * time.Second):
+		t.Fatal("no delivery")
+	}
+	return Delivery{}
+}
+
+func TestRedisStreamConsumeMessagesRedeliversNacked(t *testing.T) {
+	s, client := newTestRedisStream(t, config.RedisStreamConfig{Block: 50 * time.Millisecond, ClaimIdle: 100 * time.Millisecond})
+
+	deliveries, err := s.ConsumeMessages("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PublishMessageByExchange("", "jobs", "job-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, deliveries)
+	if string(first.Body) != "job-1" {
+		t.Fatalf("unexpected delivery %+v", first)
+	}
+	if err := first.Nack(true); err != nil {
+		t.Fatal(err)
+	}
+
+	again := receive(t, deliveries)
+	if again.Headers["x-stream-id"] != first.Headers["x-stream-id"] || string(again.Body) != "job-1" {
+		t.Fatalf("nacked message should be redelivered, got %+v", again)
+	}
+	if err := again.Ack(); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := client.XPending(context.Background(), s.streamKey("jobs"), s.cfg.Group).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Count != 0 {
+		t.Fatalf("pending = %d after ack", pending.Count)
+	}
+}
+
+func TestRedisStreamDeadLettersPoisonMessage(t *testing.T) {
+	s, client := newTestRedisStream(t, config.RedisStreamConfig{Block: 20 * time.Millisecond, ClaimIdle: 40 * time.Millisecond})
+	if s.cfg.MaxDeliveries != defaultMaxDeliveries {
+		t.Fatalf("MaxDeliveries = %d, want default %d", s.cfg.MaxDeliveries, defaultMaxDeliveries)
+	}
+
+	deliveries, err := s.ConsumeMessages("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PublishMessageByExchange("", "jobs", "poison"); err != nil {
+		t.Fatal(err)
+	}
+
+	dlq := s.streamKey("jobs") + ".dlq"
+	deadline := time.After(5 * time.Second)
+	for attempts := 0; ; {
+		select {
+		case d := <-deliveries:
+			attempts++
+			if attempts > defaultMaxDeliveries {
+				t.Fatalf("delivered %d times, MaxDeliveries is %d", attempts, defaultMaxDeliveries)
+			}
+			d.Nack(true)
+		case <-deadline:
+			t.Fatal("poison message was not dead lettered")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		if n, _ := client.XLen(context.Background(), dlq).Result(); n == 1 {
+			break
+		}
+	}
+
+	msgs, err := client.XRange(context.Background(), dlq, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs[0].Values["data"] != "poison" || msgs[0].Values["source"] != s.streamKey("jobs") {
+		t.Fatalf("unexpected dead letter %v", msgs[0].Values)
+	}
+	pending, _ := client.XPending(context.Background(), s.streamKey("jobs"), s.cfg.Group).Result()
+	if pending.Count != 0 {
+		t.Fatalf("pending = %d after dead letter", pending.Count)
+	}
+}
+
+func TestRedisStreamCloseStopsConsumeMessages(t *testing.T) {
+	s, _ := newTestRedisStream(t, config.RedisStreamConfig{Block: 50 * time.Millisecond})
+
+	deliveries, err := s.ConsumeMessages("jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Close did not return")
+	}
+
+	// Close 返回时读取协程已退出，chan 已关闭
+	select {
+	case _, ok := <-deliveries:
+		if ok {
+			t.Fatal("unexpected delivery after Close")
+		}
+	default:
+		t.Fatal("deliveries not closed after Close")
+	}
+}
+
+func TestRedisStreamDeadLetterMaxLen(t *testing.T) {
+	s, client := newTestRedisStream(t, config.RedisStreamConfig{MaxLen: 2})
+
+	stream := s.streamKey("jobs")
+	for i := 0; i < 5; i++ {
+		s.deadLetter(context.Background(), stream, goredis.XMessage{ID: fmt.Sprintf("1-%d", i), Values: map[string]interface{}{"data": "poison"}})
+	}
+
+	n, err := client.XLen(context.Background(), stream+".dlq").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n > 2 {
+		t.Fatalf("dead letter stream len = %d, MaxLen is 2", n)
+	}
+}
@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"fmt"
+	"github.com/kmcqqq/pkg/logger"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const (
+	// AttemptHeader 已失败的处理次数
+	AttemptHeader = "x-attempt"
+	// SourceQueueHeader 进入死信队列前所在的队列，Redrive 按此投回
+	SourceQueueHeader = "x-source-queue"
+	// OriginalKeyHeader 首次投递时的 routing key，重试后 Message.Key 仍为原值
+	OriginalKeyHeader = "x-original-routing-key"
+	// LastErrorHeader 最后一次处理失败的错误
+	LastErrorHeader = "x-last-error"
+
+	defaultMaxAttempts   = 3
+	defaultRetryDelay    = 1 * time.Second
+	defaultRetryMaxDelay = 1 * time.Minute
+)
+
+// RetryPolicy 手动 ack 消费的重试策略，处理失败后按 DelayStrategy 经 DelayExchange 延迟投回原队列，
+// 达到 MaxAttempts 后转入死信队列；零值字段使用默认值
+type RetryPolicy struct {
+	// MaxAttempts 最多处理次数（含首次），默认 3
+	MaxAttempts int
+	// Delay 首次重试延迟，之后每次翻倍，默认 1s
+	Delay time.Duration
+	// MaxDelay 重试延迟上限，默认 1m
+	MaxDelay time.Duration
+	// DeadLetterQueue 死信队列名，默认 <queue>.dlq
+	DeadLetterQueue string
+}
+
+func (p *RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// delay 第 failures 次失败后的重试延迟
+func (p *RetryPolicy) delay(failures int) time.Duration {
+	delay, maxDelay := p.Delay, p.MaxDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (p *RetryPolicy) deadLetterQueue(queue string) string {
+	if p.DeadLetterQueue != "" {
+		return p.DeadLetterQueue
+	}
+	return queue + ".dlq"
+}
+
+// declareRetry 声明死信队列，并把消费队列以队列名为 routing key 绑定到 DelayExchange 用于重试投回
+func (c *RabbitMQConsumer) declareRetry(channel *amqp.Channel) error {
+	if _, err := channel.QueueDeclare(c.cfg.Retry.deadLetterQueue(c.queue), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed to declare dead letter queue: %w", err)
+	}
+	if err := channel.QueueBind(c.queue, c.queue, DelayExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind retry routing: %w", err)
+	}
+	return nil
+}
+
+// retryPlan 处理失败后的去向：deadLetter 为空时延迟 delay 后投回原队列，否则投入死信队列
+type retryPlan struct {
+	attempt    int
+	delay      time.Duration
+	deadLetter string
+	msg        amqp.Publishing
+}
+
+// plan 根据已失败次数决定重试或转入死信队列，并生成要重新发布的消息
+func (p *RetryPolicy) plan(queue string, msg amqp.Delivery, handleErr error) retryPlan {
+	failures := headerInt(msg.Headers, AttemptHeader) + 1
+
+	headers := make(amqp.Table, len(msg.Headers)+3)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[AttemptHeader] = int64(failures)
+	headers[LastErrorHeader] = handleErr.Error()
+	if _, ok := headers[OriginalKeyHeader]; !ok {
+		headers[OriginalKeyHeader] = msg.RoutingKey
+	}
+
+	plan := retryPlan{attempt: failures}
+	if failures < p.maxAttempts() {
+		plan.delay = p.delay(failures)
+		headers["x-delay"] = int(plan.delay / time.Millisecond)
+	} else {
+		delete(headers, "x-delay")
+		headers[SourceQueueHeader] = queue
+		plan.deadLetter = p.deadLetterQueue(queue)
+	}
+	plan.msg = republishing(msg, headers)
+	return plan
+}
+
+// republishing 以新的 headers 重新发布原消息，保留 MessageId 等属性
+func republishing(msg amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   msg.CorrelationId,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
+
+// settle 手动 ack 模式下处理结果：成功 ack；失败时重新发布到重试或死信队列，发布确认后再 ack 原消息，
+// 发布失败则 nack 并 requeue，消息不会丢失
+func (c *RabbitMQConsumer) settle(msg amqp.Delivery, handleErr error) {
+	if handleErr == nil {
+		if err := msg.Ack(false); err != nil {
+			logger.Error("rabbitmq", logger.String("text", "ack failed"), logger.String("topic", c.queue), logger.Err(err))
+		}
+		return
+	}
+
+	plan := c.cfg.Retry.plan(c.queue, msg, handleErr)
+
+	var err error
+	if plan.deadLetter == "" {
+		err = c.client.publishDelayed(c.queue, plan.msg, plan.delay, true)
+		logger.Warn("rabbitmq", logger.String("text", "retry message"), logger.String("topic", c.queue), logger.Int("attempt", plan.attempt), logger.Duration("delay", plan.delay), logger.Err(handleErr))
+	} else {
+		err = c.client.publish("", plan.deadLetter, true, true, plan.msg)
+		logger.Error("rabbitmq", logger.String("text", "dead letter message"), logger.String("topic", c.queue), logger.String("dlq", plan.deadLetter), logger.Int("attempt", plan.attempt), logger.String("data", string(msg.Body)), logger.Err(handleErr))
+	}
+
+	if err != nil {
+		logger.Error("rabbitmq", logger.String("text", "republish failed, requeue"), logger.String("topic", c.queue), logger.Err(err))
+		if err := msg.Nack(false, true); err != nil {
+			logger.Error("rabbitmq", logger.String("text", "nack failed"), logger.String("topic", c.queue), logger.Err(err))
+		}
+		return
+	}
+	if err := msg.Ack(false); err != nil {
+		logger.Error("rabbitmq", logger.String("text", "ack failed"), logger.String("topic", c.queue), logger.Err(err))
+	}
+}
+
+// Redrive 把死信队列中的消息投回原队列并清零重试次数，limit 小于等于 0 时处理调用时队列中的全部消息，返回投回条数
+func (r *RabbitMQ) Redrive(dlq string, limit int) (int, error) {
+	channel, err := r.openChannel()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+
+	queue, err := channel.QueueInspect(dlq)
+	if err != nil {
+		return 0, err
+	}
+	if limit <= 0 || limit > queue.Messages {
+		limit = queue.Messages
+	}
+
+	for n := 0; n < limit; n++ {
+		msg, ok, err := channel.Get(dlq, false)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			return n, nil
+		}
+
+		source, republish, err := redrivePublishing(msg)
+		if err != nil {
+			msg.Nack(false, true)
+			return n, fmt.Errorf("queue: message in %s: %w", dlq, err)
+		}
+
+		if err := r.publish("", source, true, true, republish); err != nil {
+			msg.Nack(false, true)
+			return n, err
+		}
+		if err := msg.Ack(false); err != nil {
+			return n + 1, err
+		}
+	}
+
+	if limit > 0 {
+		logger.Info("rabbitmq", logger.String("text", "redrive dead letter"), logger.String("dlq", dlq), logger.Int("count", limit))
+	}
+	return limit, nil
+}
+
+// redrivePublishing 返回死信消息的原队列和清零重试次数后的消息
+func redrivePublishing(msg amqp.Delivery) (string, amqp.Publishing, error) {
+	source, _ := msg.Headers[SourceQueueHeader].(string)
+	if source == "" {
+		return "", amqp.Publishing{}, fmt.Errorf("no %s header", SourceQueueHeader)
+	}
+
+	headers := make(amqp.Table, len(msg.Headers))
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	delete(headers, AttemptHeader)
+	delete(headers, SourceQueueHeader)
+	return source, republishing(msg, headers), nil
+}
+
+// headerInt 读取整数 header，broker 回传时类型可能为 int32 或 int64
+func headerInt(headers amqp.Table, key string) int {
+	switch v := headers[key].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,109 @@
+package queue
+
+import (
+	"errors"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := &RetryPolicy{Delay: time.Second, MaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range want {
+		if got := p.delay(i + 1); got != d {
+			t.Errorf("delay(%d) = %s, want %s", i+1, got, d)
+		}
+	}
+
+	var zero RetryPolicy
+	if zero.maxAttempts() != defaultMaxAttempts || zero.delay(1) != defaultRetryDelay || zero.deadLetterQueue("orders") != "orders.dlq" {
+		t.Fatal("zero policy should use defaults")
+	}
+}
+
+func TestRetryPolicyPlan(t *testing.T) {
+	p := &RetryPolicy{MaxAttempts: 3, Delay: time.Second}
+	handleErr := errors.New("db down")
+	msg := amqp.Delivery{
+		MessageId:  "m-1",
+		RoutingKey: "orders.created",
+		Headers:    amqp.Table{"trace": "t-1"},
+		Body:       []byte("payload"),
+	}
+
+	// 第一次失败：延迟重试，记录原 routing key
+	plan := p.plan("orders", msg, handleErr)
+	if plan.deadLetter != "" || plan.attempt != 1 || plan.delay != time.Second {
+		t.Fatalf("unexpected first plan %+v", plan)
+	}
+	h := plan.msg.Headers
+	if h[AttemptHeader] != int64(1) || h[LastErrorHeader] != "db down" || h[OriginalKeyHeader] != "orders.created" || h["trace"] != "t-1" {
+		t.Fatalf("unexpected headers %v", h)
+	}
+	if plan.msg.MessageId != "m-1" || string(plan.msg.Body) != "payload" || plan.msg.DeliveryMode != amqp.Persistent {
+		t.Fatalf("message properties not preserved: %+v", plan.msg)
+	}
+	if _, ok := msg.Headers[AttemptHeader]; ok {
+		t.Fatal("plan must not modify the delivery headers")
+	}
+
+	// 重试投回后 routing key 变为队列名，原 key 保持不变
+	msg.Headers = plan.msg.Headers
+	msg.Headers["x-delay"] = int64(1000)
+	msg.RoutingKey = "orders"
+	plan = p.plan("orders", msg, handleErr)
+	if plan.deadLetter != "" || plan.attempt != 2 || plan.delay != 2*time.Second || plan.msg.Headers[OriginalKeyHeader] != "orders.created" {
+		t.Fatalf("unexpected second plan %+v", plan)
+	}
+	if plan.msg.Headers["x-delay"] != 2000 {
+		t.Fatalf("x-delay = %v, want 2000", plan.msg.Headers["x-delay"])
+	}
+
+	// 达到 MaxAttempts 转入死信队列
+	msg.Headers = plan.msg.Headers
+	plan = p.plan("orders", msg, handleErr)
+	if plan.deadLetter != "orders.dlq" || plan.attempt != 3 {
+		t.Fatalf("unexpected dead letter plan %+v", plan)
+	}
+	if plan.msg.Headers[SourceQueueHeader] != "orders" {
+		t.Fatalf("missing source queue header: %v", plan.msg.Headers)
+	}
+	if _, ok := plan.msg.Headers["x-delay"]; ok {
+		t.Fatal("x-delay should be removed before dead lettering")
+	}
+}
+
+func TestRedrivePublishing(t *testing.T) {
+	msg := amqp.Delivery{
+		MessageId: "m-1",
+		Headers: amqp.Table{
+			AttemptHeader:     int32(3),
+			SourceQueueHeader: "orders",
+			OriginalKeyHeader: "orders.created",
+			LastErrorHeader:   "db down",
+		},
+		Body: []byte("payload"),
+	}
+
+	source, republish, err := redrivePublishing(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source != "orders" || republish.MessageId != "m-1" {
+		t.Fatalf("unexpected redrive %s %+v", source, republish)
+	}
+	if _, ok := republish.Headers[AttemptHeader]; ok {
+		t.Fatal("attempts should be reset")
+	}
+	if _, ok := republish.Headers[SourceQueueHeader]; ok {
+		t.Fatal("source queue header should be removed")
+	}
+	if headerInt(republish.Headers, AttemptHeader) != 0 || headerInt(msg.Headers, AttemptHeader) != 3 {
+		t.Fatal("unexpected attempt headers")
+	}
+
+	if _, _, err := redrivePublishing(amqp.Delivery{}); err == nil {
+		t.Fatal("message without source queue should fail")
+	}
+}
@@ -0,0 +1,161 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/streadway/amqp"
+	"strings"
+)
+
+// router 在进程内按交换机和绑定计算目标队列，供不支持交换机的后端（内存、Redis Streams）模拟 RabbitMQ 路由
+type router struct {
+	exchanges map[string]*routeExchange
+	queues    map[string]bool
+	// implicitQueues 为 true 时默认交换机直接以 routing key 作为队列名，无需声明
+	implicitQueues bool
+}
+
+type routeExchange struct {
+	kind     string
+	bindings []routeBinding
+}
+
+type routeBinding struct {
+	queue string
+	key   string
+}
+
+// newRouter 预置 amq.direct/amq.fanout/amq.topic、fanout 的 NotifyExchange 和 DelayExchange
+func newRouter(implicitQueues bool) *router {
+	return &router{
+		exchanges: map[string]*routeExchange{
+			"amq.direct":   {kind: amqp.ExchangeDirect},
+			"amq.fanout":   {kind: amqp.ExchangeFanout},
+			"amq.topic":    {kind: amqp.ExchangeTopic},
+			NotifyExchange: {kind: amqp.ExchangeFanout},
+			DelayExchange:  {kind: amqp.ExchangeDirect},
+		},
+		queues:         make(map[string]bool),
+		implicitQueues: implicitQueues,
+	}
+}
+
+// declare 幂等声明，x-delayed-message 按 DelayedType 路由，不支持 headers 交换机
+func (r *router) declare(topology *config.QueueTopology) error {
+	if topology == nil {
+		return nil
+	}
+
+	for _, spec := range topology.Exchanges {
+		if spec.Name == "" {
+			return errors.New("queue: exchange name is required")
+		}
+		kind := spec.Kind
+		if kind == "" {
+			kind = amqp.ExchangeDirect
+		}
+		if kind == delayedMessageKind {
+			kind = spec.DelayedType
+			if kind == "" {
+				kind = amqp.ExchangeDirect
+			}
+		}
+		if kind != amqp.ExchangeDirect && kind != amqp.ExchangeFanout && kind != amqp.ExchangeTopic {
+			return fmt.Errorf("queue: exchange kind %s is not supported", kind)
+		}
+		if ex, ok := r.exchanges[spec.Name]; ok {
+			if ex.kind != kind {
+				return fmt.Errorf("queue: exchange %s already declared as %s", spec.Name, ex.kind)
+			}
+			continue
+		}
+		r.exchanges[spec.Name] = &routeExchange{kind: kind}
+	}
+
+	for _, spec := range topology.Queues {
+		if spec.Name == "" {
+			return errors.New("queue: queue name is required")
+		}
+		r.queues[spec.Name] = true
+	}
+
+	for _, spec := range topology.Bindings {
+		ex, ok := r.exchanges[spec.Exchange]
+		if !ok {
+			return fmt.Errorf("queue: exchange %s not found", spec.Exchange)
+		}
+		if !r.queues[spec.Queue] {
+			return fmt.Errorf("queue: queue %s not found", spec.Queue)
+		}
+		binding := routeBinding{queue: spec.Queue, key: spec.RoutingKey}
+		exists := false
+		for _, b := range ex.bindings {
+			if b == binding {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			ex.bindings = append(ex.bindings, binding)
+		}
+	}
+	return nil
+}
+
+// route 返回匹配的队列，交换机不存在返回错误，无匹配返回空
+func (r *router) route(exchange, key string) ([]string, error) {
+	if exchange == "" {
+		if r.implicitQueues || r.queues[key] {
+			return []string{key}, nil
+		}
+		return nil, nil
+	}
+
+	ex, ok := r.exchanges[exchange]
+	if !ok {
+		return nil, fmt.Errorf("queue: exchange %s not found", exchange)
+	}
+
+	var targets []string
+	seen := make(map[string]bool)
+	for _, b := range ex.bindings {
+		if seen[b.queue] || !ex.matches(b.key, key) {
+			continue
+		}
+		seen[b.queue] = true
+		targets = append(targets, b.queue)
+	}
+	return targets, nil
+}
+
+func (e *routeExchange) matches(bindingKey, routingKey string) bool {
+	switch e.kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatch(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+	default:
+		return bindingKey == routingKey
+	}
+}
+
+// topicMatch * 匹配一个单词，# 匹配零个或多个单词
+func topicMatch(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatch(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatch(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatch(pattern[1:], words[1:])
+	}
+}
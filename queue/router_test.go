@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"github.com/kmcqqq/pkg/config"
+	"github.com/streadway/amqp"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopicMatch(t *testing.T) {
+	cases := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"order.created", "order.created", true},
+		{"order.created", "order.paid", false},
+		{"order.*", "order.created", true},
+		{"order.*", "order", false},
+		{"order.*", "order.created.v2", false},
+		{"*.created", "order.created", true},
+		{"order.#", "order", true},
+		{"order.#", "order.created.v2", true},
+		{"#", "", true},
+		{"#", "a.b.c", true},
+		{"#.paid", "order.paid", true},
+		{"#.paid", "paid", true},
+		{"#.paid", "order.created", false},
+		{"order.#.v2", "order.created.v2", true},
+		{"order.#.v2", "order.v2", true},
+		{"order.#.v2", "order.created.v1", false},
+		{"*.*", "a.b", true},
+		{"*.*", "a", false},
+	}
+	for _, tc := range cases {
+		if got := topicMatch(strings.Split(tc.pattern, "."), strings.Split(tc.key, ".")); got != tc.want {
+			t.Errorf("topicMatch(%q, %q) = %v, want %v", tc.pattern, tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	r := newRouter(false)
+	err := r.declare(&config.QueueTopology{
+		Exchanges: []config.ExchangeSpec{{Name: "events", Kind: amqp.ExchangeTopic}},
+		Queues:    []config.QueueSpec{{Name: "orders"}, {Name: "audit"}},
+		Bindings: []config.BindingSpec{
+			{Queue: "orders", Exchange: "events", RoutingKey: "order.*"},
+			{Queue: "audit", Exchange: "events", RoutingKey: "#"},
+			{Queue: "audit", Exchange: "events", RoutingKey: "order.#"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := r.route("events", "order.created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []string{"orders", "audit"}) {
+		t.Fatalf("targets = %v, each queue should appear once", targets)
+	}
+	if targets, _ := r.route("events", "user.login"); !reflect.DeepEqual(targets, []string{"audit"}) {
+		t.Fatalf("targets = %v", targets)
+	}
+
+	if targets, _ := r.route("", "orders"); !reflect.DeepEqual(targets, []string{"orders"}) {
+		t.Fatalf("default exchange should route by queue name, got %v", targets)
+	}
+	if targets, _ := r.route("", "missing"); len(targets) != 0 {
+		t.Fatalf("undeclared queue should not be routed, got %v", targets)
+	}
+	if _, err := r.route("missing", "x"); err == nil {
+		t.Fatal("unknown exchange should fail")
+	}
+
+	if err := r.declare(&config.QueueTopology{Exchanges: []config.ExchangeSpec{{Name: "events", Kind: amqp.ExchangeFanout}}}); err == nil {
+		t.Fatal("redeclaring with a different kind should fail")
+	}
+}
@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+const (
+	// NotifyExchange MessageService 推送通知使用的交换机
+	NotifyExchange = "notify"
+
+	delayedMessageKind = "x-delayed-message"
+)
+
+// DefaultTopology 本包依赖的交换机：fanout 的 notify 和延迟交换机 DelayExchange，
+// 插件策略下需要 rabbitmq_delayed_message_exchange 插件
+func DefaultTopology() *config.QueueTopology {
+	return &config.QueueTopology{
+		Exchanges: []config.ExchangeSpec{
+			{Name: NotifyExchange, Kind: amqp.ExchangeFanout, Durable: true},
+			{Name: DelayExchange, Kind: delayedMessageKind, Durable: true, DelayedType: amqp.ExchangeDirect},
+		},
+	}
+}
+
+// Declare 在当前连接上声明拓扑，已存在且参数一致时不做修改；TTL 延迟策略下 x-delayed-message 交换机按 DelayedType 声明
+func (r *RabbitMQ) Declare(topology *config.QueueTopology) error {
+	channel, err := r.openChannel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	return declareTopology(channel, r.adaptTopology(topology))
+}
+
+// declareTopology 按交换机、队列、绑定的顺序声明，参数与已有定义冲突时 broker 会关闭通道，因此使用独立通道
+func declareTopology(channel *amqp.Channel, topology *config.QueueTopology) error {
+	if topology == nil {
+		return nil
+	}
+
+	for _, spec := range topology.Exchanges {
+		if spec.Name == "" {
+			return errors.New("queue: exchange name is required")
+		}
+		kind := spec.Kind
+		if kind == "" {
+			kind = amqp.ExchangeDirect
+		}
+		args := toTable(spec.Args)
+		if kind == delayedMessageKind {
+			delayedType := spec.DelayedType
+			if delayedType == "" {
+				delayedType = amqp.ExchangeDirect
+			}
+			args["x-delayed-type"] = delayedType
+		}
+		if err := channel.ExchangeDeclare(spec.Name, kind, spec.Durable, spec.AutoDelete, false, false, args); err != nil {
+			return fmt.Errorf("failed to declare exchange %s: %w", spec.Name, err)
+		}
+	}
+
+	for _, spec := range topology.Queues {
+		if spec.Name == "" {
+			return errors.New("queue: queue name is required")
+		}
+		if _, err := channel.QueueDeclare(spec.Name, spec.Durable, spec.AutoDelete, spec.Exclusive, false, queueArgs(spec)); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", spec.Name, err)
+		}
+	}
+
+	for _, spec := range topology.Bindings {
+		if err := channel.QueueBind(spec.Queue, spec.RoutingKey, spec.Exchange, false, toTable(spec.Args)); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %w", spec.Queue, spec.Exchange, err)
+		}
+	}
+	return nil
+}
+
+func queueArgs(spec config.QueueSpec) amqp.Table {
+	args := toTable(spec.Args)
+	if spec.MessageTTL > 0 {
+		args["x-message-ttl"] = int64(spec.MessageTTL / time.Millisecond)
+	}
+	if spec.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = spec.DeadLetterExchange
+	}
+	if spec.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = spec.DeadLetterRoutingKey
+	}
+	if spec.MaxLength > 0 {
+		args["x-max-length"] = int64(spec.MaxLength)
+	}
+	return args
+}
+
+// toTable 复制参数，配置文件中的嵌套 map 转为 amqp.Table
+func toTable(args map[string]interface{}) amqp.Table {
+	table := make(amqp.Table, len(args))
+	for k, v := range args {
+		if m, ok := v.(map[string]interface{}); ok {
+			v = toTable(m)
+		}
+		table[k] = v
+	}
+	return table
+}
@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"github.com/kmcqqq/pkg/config"
+	"github.com/streadway/amqp"
+	"testing"
+	"time"
+)
+
+func TestQueueArgs(t *testing.T) {
+	args := queueArgs(config.QueueSpec{
+		Name:                 "orders",
+		MessageTTL:           30 * time.Second,
+		DeadLetterExchange:   "dlx",
+		DeadLetterRoutingKey: "orders.dead",
+		MaxLength:            1000,
+		Args: map[string]interface{}{
+			"x-queue-type": "quorum",
+			"nested":       map[string]interface{}{"k": "v"},
+		},
+	})
+
+	if args["x-message-ttl"] != int64(30000) || args["x-dead-letter-exchange"] != "dlx" ||
+		args["x-dead-letter-routing-key"] != "orders.dead" || args["x-max-length"] != int64(1000) || args["x-queue-type"] != "quorum" {
+		t.Fatalf("unexpected args %v", args)
+	}
+	if nested, ok := args["nested"].(amqp.Table); !ok || nested["k"] != "v" {
+		t.Fatalf("nested args should be converted to amqp.Table: %#v", args["nested"])
+	}
+
+	if args := queueArgs(config.QueueSpec{Name: "plain"}); len(args) != 0 {
+		t.Fatalf("zero spec should have no args, got %v", args)
+	}
+}
+
+func TestDefaultTopology(t *testing.T) {
+	topology := DefaultTopology()
+	kinds := map[string]string{}
+	for _, spec := range topology.Exchanges {
+		kinds[spec.Name] = spec.Kind
+	}
+	if kinds[NotifyExchange] != amqp.ExchangeFanout || kinds[DelayExchange] != delayedMessageKind {
+		t.Fatalf("unexpected exchanges %v", kinds)
+	}
+}
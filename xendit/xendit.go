@@ -1,34 +1,83 @@
 package xendit
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"github.com/kmcqqq/pkg/config"
 	"github.com/kmcqqq/pkg/httpHelper"
 	"github.com/kmcqqq/pkg/utils"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// DefaultUrl xendit 正式/测试环境共用同一域名，通过 key 区分
+const DefaultUrl = "https://api.xendit.co"
+
+const sandboxKeyPrefix = "xnd_development_"
+
+// ErrSandboxLiveKey sandbox 模式下配置了正式 key，拒绝创建客户端和代付，避免测试配置发起真实打款
+var ErrSandboxLiveKey = errors.New("xendit: sandbox client must use a " + sandboxKeyPrefix + " key")
+
 type Client struct {
+	httpHelper.ClientHolder
 	SecretKey   string
 	PublicKey   string
 	VerifyToken string
 	BusinessId  string
+	Url         string
+	// Sandbox 测试模式，SecretKey 必须为测试 key
+	Sandbox bool
 }
 
 func NewClient(cfg *config.PayConfig) (map[string]*Client, error) {
 	clients := make(map[string]*Client)
 	for key, value := range cfg.Xendit {
-		clients[key] = &Client{
+		baseUrl := strings.TrimSuffix(value.Url, "/")
+		if baseUrl == "" {
+			baseUrl = DefaultUrl
+		}
+		client := &Client{
 			SecretKey:   value.SecretKey,
 			PublicKey:   value.PublicKey,
 			VerifyToken: value.VerifyToken,
 			BusinessId:  value.BusinessId,
+			Url:         baseUrl,
+			Sandbox:     value.Sandbox,
+		}
+		if err := client.checkMode(); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, key)
 		}
+		clients[key] = client
 	}
 	return clients, nil
 }
 
+// WithContext 返回绑定 ctx 的浅拷贝，请求随 ctx 取消
+func (x *Client) WithContext(ctx context.Context) *Client {
+	client := *x
+	client.ClientHolder = x.ClientHolder.WithContext(ctx)
+	return &client
+}
+
+// IsSandbox 是否为测试模式 key
+func (x *Client) IsSandbox() bool {
+	return strings.HasPrefix(x.SecretKey, sandboxKeyPrefix)
+}
+
+// checkMode sandbox 模式必须使用测试 key
+func (x *Client) checkMode() error {
+	if x.Sandbox && !x.IsSandbox() {
+		return ErrSandboxLiveKey
+	}
+	return nil
+}
+
 type InvoiceRequest struct {
 	ExternalId     string   `json:"external_id"`
 	Amount         float64  `json:"amount"`
@@ -45,6 +94,7 @@ type InvoiceResponse struct {
 	ExternalID   string    `json:"external_id"`
 	Status       string    `json:"status"`
 	Amount       float64   `json:"amount"`
+	Currency     string    `json:"currency"`
 	ExpiryDate   time.Time `json:"expiry_date"`
 	InvoiceURL   string    `json:"invoice_url"`
 	ErrorCode    string    `json:"error_code"`
@@ -52,11 +102,12 @@ type InvoiceResponse struct {
 }
 
 func (x *Client) CreateInvoice(invoice InvoiceRequest) (*InvoiceResponse, error) {
-	url := "https://api.xendit.co/v2/invoices"
+	url := fmt.Sprintf("%s/v2/invoices", x.Url)
 	header := map[string]string{
 		"Authorization": fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
 	}
-	result, err := httpHelper.Post(url, invoice, header)
+	ctx, client := x.Client()
+	result, err := client.Post(ctx, url, invoice, header)
 	if err != nil {
 		return nil, err
 	}
@@ -76,11 +127,12 @@ func (x *Client) CreateInvoice(invoice InvoiceRequest) (*InvoiceResponse, error)
 }
 
 func (x *Client) GetInvoice(orderId string) (*InvoiceResponse, error) {
-	url := "https://api.xendit.co/v2/invoices"
+	url := fmt.Sprintf("%s/v2/invoices", x.Url)
 	header := map[string]string{
 		"Authorization": fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
 	}
-	result, err := httpHelper.GetHeader(fmt.Sprintf("%s/?external_id=%s", url, orderId), header)
+	ctx, client := x.Client()
+	result, err := client.GetHeader(ctx, fmt.Sprintf("%s/?external_id=%s", url, orderId), header)
 	if err != nil {
 		return nil, err
 	}
@@ -98,6 +150,29 @@ func (x *Client) GetInvoice(orderId string) (*InvoiceResponse, error) {
 	return &res, err
 }
 
+// ExpireInvoice 使未支付的发票立即过期，invoiceId 为 xendit 发票 id
+func (x *Client) ExpireInvoice(invoiceId string) (*InvoiceResponse, error) {
+	endpoint := fmt.Sprintf("%s/invoices/%s/expire!", x.Url, url.PathEscape(invoiceId))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
+	}
+	ctx, client := x.Client()
+	result, err := client.Post(ctx, endpoint, struct{}{}, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var response InvoiceResponse
+	if err = utils.Json2Struct(result, &response); err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != "" {
+		return nil, errors.New(response.ErrorMessage)
+	}
+
+	return &response, nil
+}
+
 type InvoiceNotify struct {
 	ID                     string  `json:"id"`
 	ExternalID             string  `json:"external_id"`
@@ -174,14 +249,18 @@ type PayoutResponse struct {
 }
 
 func (x *Client) PayOut(payout PayoutRequest) (*PayoutResponse, error) {
-	url := "https://api.xendit.co/v2/payouts"
+	if err := x.checkMode(); err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/v2/payouts", x.Url)
 	requestId := utils.GenerateRequestId()
 
 	header := map[string]string{
 		"Authorization":   fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
 		"Idempotency-key": requestId,
 	}
-	result, err := httpHelper.Post(url, payout, header)
+	ctx, client := x.Client()
+	result, err := client.Post(ctx, url, payout, header)
 	if err != nil {
 		return nil, err
 	}
@@ -192,16 +271,88 @@ func (x *Client) PayOut(payout PayoutRequest) (*PayoutResponse, error) {
 	return &response, err
 }
 
+// GetPayout 根据 xendit payout id 查询代付
+func (x *Client) GetPayout(id string) (*PayoutResponse, error) {
+	endpoint := fmt.Sprintf("%s/v2/payouts/%s", x.Url, url.PathEscape(id))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
+	}
+	ctx, client := x.Client()
+	result, err := client.GetHeader(ctx, endpoint, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var response PayoutResponse
+	if err = utils.Json2Struct(result, &response); err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != "" {
+		return nil, errors.New(response.Message)
+	}
+
+	return &response, nil
+}
+
+// GetPayoutsByReference 根据商户订单号查询代付
+func (x *Client) GetPayoutsByReference(referenceId string) ([]PayoutResponse, error) {
+	endpoint := fmt.Sprintf("%s/v2/payouts?reference_id=%s", x.Url, url.QueryEscape(referenceId))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
+	}
+	ctx, client := x.Client()
+	result, err := client.GetHeader(ctx, endpoint, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if !utils.IsJSONArray(result) {
+		var response PayoutResponse
+		if err = utils.Json2Struct(result, &response); err != nil {
+			return nil, err
+		}
+		return nil, errors.New(response.Message)
+	}
+
+	var arr []PayoutResponse
+	err = utils.Json2Struct(result, &arr)
+	return arr, err
+}
+
+// CancelPayout 取消代付，仅 ACCEPTED 状态可取消
+func (x *Client) CancelPayout(id string) (*PayoutResponse, error) {
+	endpoint := fmt.Sprintf("%s/v2/payouts/%s/cancel", x.Url, url.PathEscape(id))
+	header := map[string]string{
+		"Authorization": fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
+	}
+	ctx, client := x.Client()
+	result, err := client.Post(ctx, endpoint, struct{}{}, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var response PayoutResponse
+	if err = utils.Json2Struct(result, &response); err != nil {
+		return nil, err
+	}
+	if response.ErrorCode != "" {
+		return nil, errors.New(response.Message)
+	}
+
+	return &response, nil
+}
+
 type BalanceResponse struct {
 	Balance float64 `json:"balance"`
 }
 
 func (x *Client) GetBalance() (*BalanceResponse, error) {
-	url := "https://api.xendit.co/balance"
+	url := fmt.Sprintf("%s/balance", x.Url)
 	header := map[string]string{
 		"Authorization": fmt.Sprintf("Basic %s", utils.EncodeStr2Base64(x.SecretKey)),
 	}
-	result, err := httpHelper.GetHeader(url, header)
+	ctx, client := x.Client()
+	result, err := client.GetHeader(ctx, url, header)
 	if err != nil {
 		return nil, err
 	}
@@ -248,3 +399,56 @@ type PayOutNotify struct {
 	Created    string         `json:"created"`
 	Data       PayoutResponse `json:"data"`
 }
+
+var ErrInvalidToken = errors.New("xendit: invalid callback token")
+
+// Callback 验证后的回调，Event 为空表示发票回调
+type Callback struct {
+	Event   string
+	Invoice *InvoiceCallback
+	PayOut  *PayOutNotify
+}
+
+// VerifyCallback 校验 x-callback-token 并解析回调内容，可直接传入 gin 的 c.Request
+func (x *Client) VerifyCallback(r *http.Request) (*Callback, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return x.VerifyCallbackBody(r.Header.Get("x-callback-token"), body)
+}
+
+// VerifyCallbackBody 校验 token 并按 event 解析回调
+func (x *Client) VerifyCallbackBody(token string, body []byte) (*Callback, error) {
+	if x.VerifyToken == "" || subtle.ConstantTimeCompare([]byte(x.VerifyToken), []byte(token)) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	var head struct {
+		Event string `json:"event"`
+	}
+	if err := utils.Json2Struct(string(body), &head); err != nil {
+		return nil, err
+	}
+
+	callback := &Callback{Event: head.Event}
+	if head.Event == "" {
+		callback.Invoice = &InvoiceCallback{}
+		if err := utils.Json2Struct(string(body), callback.Invoice); err != nil {
+			return nil, err
+		}
+		return callback, nil
+	}
+
+	if !strings.HasPrefix(head.Event, "payout.") {
+		return nil, fmt.Errorf("xendit: unsupported callback event %s", head.Event)
+	}
+	callback.PayOut = &PayOutNotify{}
+	if err := utils.Json2Struct(string(body), callback.PayOut); err != nil {
+		return nil, err
+	}
+	return callback, nil
+}
@@ -0,0 +1,67 @@
+package xendit
+
+import (
+	"errors"
+	"github.com/kmcqqq/pkg/config"
+	"github.com/kmcqqq/pkg/logger"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitLogger(&config.LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+func TestNewClientSandbox(t *testing.T) {
+	_, err := NewClient(&config.PayConfig{Xendit: map[string]*config.XenditConfig{
+		"id": {SecretKey: "xnd_production_abc", Sandbox: true},
+	}})
+	if !errors.Is(err, ErrSandboxLiveKey) {
+		t.Fatalf("sandbox config with live key: err = %v", err)
+	}
+
+	clients, err := NewClient(&config.PayConfig{Xendit: map[string]*config.XenditConfig{
+		"id": {SecretKey: "xnd_development_abc", Sandbox: true},
+		"ph": {SecretKey: "xnd_production_abc", Url: "https://xendit.example.com/"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := clients["id"]; !c.Sandbox || !c.IsSandbox() || c.Url != DefaultUrl {
+		t.Fatalf("unexpected sandbox client %+v", c)
+	}
+	if c := clients["ph"]; c.Sandbox || c.IsSandbox() || c.Url != "https://xendit.example.com" {
+		t.Fatalf("unexpected live client %+v", c)
+	}
+}
+
+func TestClientUrl(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`{"id":"inv-1","external_id":"o-1","status":"PENDING","amount":10000,"currency":"IDR"}`))
+	}))
+	defer server.Close()
+
+	client := &Client{SecretKey: "xnd_development_abc", Url: server.URL, Sandbox: true}
+	res, err := client.CreateInvoice(InvoiceRequest{ExternalId: "o-1", Amount: 10000, Currency: "IDR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != "inv-1" || res.Currency != "IDR" {
+		t.Fatalf("unexpected invoice %+v", res)
+	}
+
+	// 手动构造的 sandbox 客户端使用正式 key 时，代付在发出请求前被拒绝
+	client.SecretKey = "xnd_production_abc"
+	if _, err := client.PayOut(PayoutRequest{ReferenceID: "p-1"}); !errors.Is(err, ErrSandboxLiveKey) {
+		t.Fatalf("payout with live key in sandbox: err = %v", err)
+	}
+
+	if len(paths) != 1 || paths[0] != "POST /v2/invoices" {
+		t.Fatalf("requests = %v", paths)
+	}
+}